@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// aclRules binds subjects to the key prefixes they may write, for
+// identities that do not carry prefixes themselves, such as Basic auth
+// users and client certificates. It is filled from --acl at startup.
+var aclRules = map[string][]string{}
+
+// protectedPrefixes are key prefixes only admins may write, such as
+// system/ for metadata the server publishes itself. It is filled from
+// --protected-prefix at startup.
+var protectedPrefixes []string
+
+// protected reports whether key in namespace ns is under a protected
+// prefix. Prefixes are matched against the key itself, so system/
+// covers system/ keys in every namespace, and against its aclKey, so
+// ns/system/ covers only those of namespace ns.
+func protected(ns, key string) bool {
+	name := aclKey(ns, key)
+	for _, p := range protectedPrefixes {
+		if strings.HasPrefix(key, p) || strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseACLFlag parses an --acl value of the form subject=prefix,prefix.
+func parseACLFlag(s string) error {
+	subject, list, ok := strings.Cut(s, "=")
+	if !ok || subject == "" || list == "" {
+		return fmt.Errorf("acl %q is not subject=prefix,...", s)
+	}
+	aclRules[subject] = append(aclRules[subject], normalizePrefixes(strings.Split(list, ","))...)
+	return nil
+}
+
+// normalizePrefixes turns patterns such as team-a/* into plain prefixes.
+func normalizePrefixes(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, strings.TrimSuffix(p, "*"))
+		}
+	}
+	return out
+}
+
+// claimPrefixes returns the prefixes listed in claim, a string of space
+// separated prefixes or a list of strings, or nil if there is no claim.
+func claimPrefixes(claims map[string]any, claim string) []string {
+	switch v := claims[claim].(type) {
+	case string:
+		return normalizePrefixes(strings.Fields(v))
+	case []any:
+		var ps []string
+		for _, p := range v {
+			if s, ok := p.(string); ok {
+				ps = append(ps, s)
+			}
+		}
+		return normalizePrefixes(ps)
+	}
+	return nil
+}
+
+// aclKey is the name ACL prefixes are matched against: the key itself
+// in the default namespace and ns/key in namespace ns.
+func aclKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+	return ns + "/" + key
+}
+
+// mayAccess reports whether id may write or subscribe to key in
+// namespace ns. Anonymous clients and identities without prefixes may
+// access every key.
+func (id *Identity) mayAccess(ns, key string) bool {
+	if id == nil || id.Prefixes == nil {
+		return true
+	}
+	name := aclKey(ns, key)
+	for _, p := range id.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowKeys reports whether the client of r may write the internal
+// keys iks, writing a 403 response if it may not. Protected keys need an
+// authenticated admin.
+func allowKeys(w http.ResponseWriter, r *http.Request, iks ...string) bool {
+	id := identity(r.Context())
+	for _, ik := range iks {
+		if err := keyAccess(id, ik); err != nil {
+			http.Error(w, err.Error(), 403)
+			return false
+		}
+	}
+	return true
+}
+
+// keyAccess reports why id may not write the internal key ik, or nil if
+// it may.
+func keyAccess(id *Identity, ik string) error {
+	ns, key := splitKey(ik)
+	if !id.mayAccess(ns, key) {
+		return errors.New("forbidden: key outside the client's prefixes")
+	}
+	if protected(ns, key) && (id == nil || id.Role < RoleAdmin) {
+		return errors.New("forbidden: protected key")
+	}
+	return nil
+}
+
+// visible returns ev as seen by a client limited to id's prefixes, and
+// false if the client should not see it at all. Batches are cut down to
+// the events the client may see.
+func (id *Identity) visible(ev Event) (Event, bool) {
+	if id == nil || id.Prefixes == nil {
+		return ev, true
+	}
+	return filterEvent(ev, func(key string) bool { return id.mayAccess(ev.Namespace, key) })
+}
+
+// filterEvent applies match to the key of ev, or to the keys of the
+// events of a batch, which is trimmed to the matching ones. Presence and
+// topic events have no key and always pass.
+func filterEvent(ev Event, match func(key string) bool) (Event, bool) {
+	if ev.Presence != nil || ev.Topic != "" {
+		return ev, true
+	}
+	if ev.Type != "batch" {
+		return ev, match(ev.Key)
+	}
+	var events []Event
+	for _, e := range ev.Events {
+		if match(e.Key) {
+			events = append(events, e)
+		}
+	}
+	ev.Events = events
+	return ev, len(events) > 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestProtected(t *testing.T) {
+	saved := protectedPrefixes
+	t.Cleanup(func() { protectedPrefixes = saved })
+	protectedPrefixes = normalizePrefixes([]string{"system/*", "team-a/config/*"})
+	for _, tc := range []struct {
+		ns, key string
+		want    bool
+	}{
+		{"", "system/leader", true},
+		{"team-a", "system/leader", true},
+		{"team-a", "config/db", true},
+		{"team-b", "config/db", false},
+		{"", "team-a/config/db", true},
+		{"", "app/system/x", false},
+		{"system", "x", true},
+	} {
+		if got := protected(tc.ns, tc.key); got != tc.want {
+			t.Errorf("protected(%q, %q) = %v, want %v", tc.ns, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestKeyAccess(t *testing.T) {
+	saved := protectedPrefixes
+	t.Cleanup(func() { protectedPrefixes = saved })
+	protectedPrefixes = []string{"system/"}
+	writer := &Identity{Subject: "w", Role: RoleWrite, Prefixes: []string{"team-a/"}}
+	admin := &Identity{Subject: "a", Role: RoleAdmin}
+	for _, tc := range []struct {
+		id   *Identity
+		ik   string
+		want bool
+	}{
+		{writer, "team-a/x", true},
+		{writer, "team-b/x", false},
+		{writer, nsKey("team-a", "x"), true},
+		{writer, nsKey("ns", "system/x"), false},
+		{admin, nsKey("ns", "system/x"), true},
+		{nil, "system/x", false},
+	} {
+		if err := keyAccess(tc.id, tc.ik); (err == nil) != tc.want {
+			t.Errorf("keyAccess(%v, %q) = %v, want allowed %v", tc.id, tc.ik, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ConnInfo describes a connected websocket client.
+type ConnInfo struct {
+	ID          uint64    `json:"id"`
+	Remote      string    `json:"remote"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Identity    *Identity `json:"identity,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Sent        int       `json:"messages_sent"`
+	Errors      int       `json:"errors"`
+	// Lag is how long writing the last message to the client took and
+	// Queued how many messages wait in its send queue; slow clients show
+	// up here before they are disconnected.
+	Lag    string `json:"lag"`
+	Queued int    `json:"queued"`
+}
+
+// Conns returns the connected websocket clients, oldest first.
+func (k *KVStore) Conns() []ConnInfo {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	out := make([]ConnInfo, 0, len(k.conns))
+	for _, c := range k.conns {
+		var name string
+		if c.presence != nil {
+			name = c.presence.Name
+		}
+		out = append(out, ConnInfo{
+			ID:          c.connID,
+			Remote:      c.conn.RemoteAddr().String(),
+			Namespace:   c.ns,
+			Identity:    c.id,
+			Name:        name,
+			ConnectedAt: c.connected,
+			Sent:        c.sent,
+			Errors:      c.errors,
+			Lag:         c.lastWrite.String(),
+			Queued:      len(c.out),
+		})
+	}
+	return out
+}
+
+// CloseConn disconnects the websocket client with the given ID, sending
+// reason in the close frame. It reports whether the client was found.
+func (k *KVStore) CloseConn(id uint64, reason string) bool {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	for _, c := range k.conns {
+		if c.connID == id {
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// connectionsHandler lists the websocket clients on GET and disconnects
+// the one given by ?id= on DELETE.
+func (kv *KVStore) connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kv.Conns())
+	case "DELETE":
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "missing or invalid id", 400)
+			return
+		}
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "closed by administrator"
+		}
+		if !kv.CloseConn(id, reason) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.InfoContext(r.Context(), "websocket closed by administrator", "id", id, "subject", subject(r.Context()))
+		fmt.Fprint(w, "ok")
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one entry of the audit log: a set or delete together
+// with the client that made it and the values before and after.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Rev       uint64    `json:"rev"`
+	Op        string    `json:"op"`
+	Namespace string    `json:"namespace,omitempty"`
+	Key       string    `json:"key"`
+	Subject   string    `json:"subject,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Old       *string   `json:"old,omitempty"`
+	New       *string   `json:"new,omitempty"`
+}
+
+// auditLog appends AuditRecords as JSON lines to a file that is never
+// rewritten.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// EnableAudit records every set and delete made through the store's
+// write methods in the audit log at path.
+func (k *KVStore) EnableAudit(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	k.audit = &auditLog{path: path, f: f}
+	return nil
+}
+
+// auditChange records c, which replaces old, if auditing is enabled. The
+// client is taken from ctx. Callers must hold k.mu.
+func (k *KVStore) auditChange(ctx context.Context, c Change, old Entry, existed bool) {
+	if k.audit == nil || (c.Op != "set" && c.Op != "delete") {
+		return
+	}
+	rec := AuditRecord{Time: c.Time, Rev: k.seq, Op: c.Op, Remote: remoteAddr(ctx), RequestID: requestID(ctx)}
+	rec.Namespace, rec.Key = splitKey(c.Key)
+	if id := identity(ctx); id != nil {
+		rec.Subject, rec.Method = id.Subject, id.Method
+	}
+	if existed {
+		v, _ := old.jsonValue()
+		rec.Old = &v
+	}
+	if c.Op == "set" {
+		v, _ := c.Entry.jsonValue()
+		rec.New = &v
+	}
+	if err := k.audit.Append(rec); err != nil {
+		slog.ErrorContext(ctx, "audit log write failed", "err", err)
+	}
+}
+
+// Append writes rec to the end of the log.
+func (a *auditLog) Append(rec AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+// auditFilter selects audit records; zero fields match everything.
+type auditFilter struct {
+	Namespace string
+	Prefix    string
+	Subject   string
+	Op        string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f auditFilter) match(rec AuditRecord) bool {
+	return rec.Namespace == f.Namespace &&
+		strings.HasPrefix(rec.Key, f.Prefix) &&
+		(f.Subject == "" || rec.Subject == f.Subject) &&
+		(f.Op == "" || rec.Op == f.Op) &&
+		(f.Since.IsZero() || !rec.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || rec.Time.Before(f.Until))
+}
+
+// Query returns the last limit records matching f, oldest first.
+func (a *auditLog) Query(f auditFilter, limit int) ([]AuditRecord, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var out []AuditRecord
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.path, err)
+		}
+		if f.match(rec) {
+			out = append(out, rec)
+			if len(out) > limit {
+				out = out[1:]
+			}
+		}
+	}
+	return out, sc.Err()
+}
+
+// auditHandler serves the audit log at /audit, filtered by the ns,
+// prefix, subject, op, since and until (RFC 3339) query parameters and
+// limited to the last limit records (default 100).
+func (kv *KVStore) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if kv.audit == nil {
+		http.Error(w, "audit log not enabled", 404)
+		return
+	}
+	q := r.URL.Query()
+	f := auditFilter{Namespace: q.Get("ns"), Prefix: q.Get("prefix"), Subject: q.Get("subject"), Op: q.Get("op")}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if s := q.Get(name); s != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				http.Error(w, "invalid "+name, 400)
+				return
+			}
+		}
+	}
+	limit := 100
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", 400)
+			return
+		}
+		limit = n
+	}
+	recs, err := kv.audit.Query(f, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reading audit log failed", "err", err)
+		http.Error(w, "failed to read audit log", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
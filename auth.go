@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	pb "github.com/matst80/go-info-share/infosharepb"
+)
+
+// Identity is the authenticated client behind a request.
+type Identity struct {
+	// Subject names the client: the sub claim of a JWT, the Basic auth
+	// user name, the name in a client certificate or "api-key:" followed
+	// by a digest prefix of the key.
+	Subject string `json:"subject"`
+	// Method is how the client authenticated: "api-key", "jwt", "basic",
+	// "mtls" or "oidc".
+	Method string `json:"method"`
+	Role   Role   `json:"role"`
+	// Prefixes limits the keys the client may write and see events for;
+	// nil means all keys. See acl.go.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Claims holds the claims of a JWT, or the names in a client
+	// certificate, for authorization checks.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+type identityKey struct{}
+
+// identity returns the client that authenticated the request ctx
+// belongs to, or nil for anonymous requests.
+func identity(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// subject returns the subject of the identity in ctx, or "" for
+// anonymous requests.
+func subject(ctx context.Context) string {
+	if id := identity(ctx); id != nil {
+		return id.Subject
+	}
+	return ""
+}
+
+// apiKeyStore holds the static API keys clients may authenticate with,
+// their roles and key prefixes. Keys are kept as SHA-256 digests so
+// lookups do not leak how much of a key matched.
+type apiKeyStore struct {
+	keys atomic.Pointer[map[[32]byte]apiKey]
+}
+
+type apiKey struct {
+	role     Role
+	prefixes []string
+}
+
+// apiKeys is the key store used for all routes.
+var apiKeys = &apiKeyStore{}
+
+// jwtAuth validates bearer JWTs; nil when JWT authentication is off.
+var jwtAuth *jwtVerifier
+
+// Load replaces the keys with keys plus those read from file, one per
+// line. Blank lines and lines starting with # are ignored. A key may be
+// prefixed with its role, as in admin:key; others get defaultRole. Key
+// prefixes the key is limited to may follow, separated by spaces, as in
+// "write:key team-a/*".
+func (s *apiKeyStore) Load(keys []string, file string) error {
+	m := make(map[[32]byte]apiKey, len(keys))
+	add := func(entry string) {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			return
+		}
+		role, key := cutRole(fields[0])
+		k := apiKey{role: role}
+		if len(fields) > 1 {
+			k.prefixes = normalizePrefixes(fields[1:])
+		}
+		m[sha256.Sum256([]byte(key))] = k
+	}
+	for _, k := range keys {
+		add(k)
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				add(line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("read %s: %w", file, err)
+		}
+	}
+	s.keys.Store(&m)
+	return nil
+}
+
+// enabled reports whether any keys are configured.
+func (s *apiKeyStore) enabled() bool {
+	m := s.keys.Load()
+	return m != nil && len(*m) > 0
+}
+
+// lookup returns the identity of key if it is one of the configured
+// keys.
+func (s *apiKeyStore) lookup(key string) (*Identity, bool) {
+	m := s.keys.Load()
+	sum := sha256.Sum256([]byte(key))
+	if key == "" || m == nil {
+		return nil, false
+	}
+	k, ok := (*m)[sum]
+	if !ok {
+		return nil, false
+	}
+	return &Identity{Subject: "api-key:" + hex.EncodeToString(sum[:4]), Method: "api-key", Role: k.role, Prefixes: k.prefixes}, true
+}
+
+// credential returns the key or token presented with r: the X-API-Key
+// header, a bearer token in the Authorization header or the api_key or
+// access_token query parameter, which browsers need for websocket
+// upgrades.
+func credential(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// authenticate returns the identity behind the credential presented
+// with r, falling back to an OIDC session cookie and then its client
+// certificate. It returns nil if there is none, or an error if the
+// credential is not valid.
+func authenticate(r *http.Request) (*Identity, error) {
+	if user, password, ok := r.BasicAuth(); ok && basicAuth.enabled() {
+		return basicAuth.Verify(user, password)
+	}
+	cred := credential(r)
+	if cred == "" && oidcAuth != nil {
+		id, err := oidcAuth.session(r)
+		// SameSite=Lax still sends the cookie on cross-site GET
+		// navigations, so it must not authorize writes sent with GET.
+		if id != nil && r.Method == "GET" && writeRoutes[r.URL.Path] {
+			return nil, fmt.Errorf("session cookies cannot authorize GET %s", r.URL.Path)
+		}
+		if id != nil || err != nil {
+			return id, err
+		}
+	}
+	if cred == "" {
+		return certIdentity(r), nil
+	}
+	return tokenIdentity(r.Context(), cred)
+}
+
+// tokenIdentity returns the identity behind cred, an API key or bearer
+// JWT.
+func tokenIdentity(ctx context.Context, cred string) (*Identity, error) {
+	if id, ok := apiKeys.lookup(cred); ok {
+		return id, nil
+	}
+	if jwtAuth != nil && strings.Count(cred, ".") == 2 {
+		return jwtAuth.Verify(ctx, cred)
+	}
+	return nil, fmt.Errorf("unknown api key")
+}
+
+// connIdentity authenticates a client of a listener other than HTTP,
+// presenting a --basic-auth user and password, or an API key or bearer
+// JWT as password. The identity is limited by --acl as in authHandler.
+func connIdentity(ctx context.Context, user, password string) (*Identity, error) {
+	var id *Identity
+	var err error
+	if user != "" && basicAuth.enabled() {
+		id, err = basicAuth.Verify(user, password)
+	} else {
+		id, err = tokenIdentity(ctx, password)
+	}
+	if err == nil && id == nil {
+		err = fmt.Errorf("unknown user")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id.Prefixes == nil {
+		id.Prefixes = aclRules[id.Subject]
+	}
+	return id, nil
+}
+
+// authEnabled reports whether any authentication method is configured.
+func authEnabled() bool {
+	return apiKeys.enabled() || jwtAuth != nil || basicAuth.enabled() || clientCertAuth || oidcAuth != nil
+}
+
+// wantsLogin reports whether r is a browser navigation that should be
+// sent to the OIDC login page rather than get a 401.
+func wantsLogin(r *http.Request) bool {
+	return oidcAuth != nil && r.Method == "GET" && !writeRoutes[r.URL.Path] &&
+		!websocket.IsWebSocketUpgrade(r) && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// changeStream reports whether r subscribes to the stream of changes,
+// which needs credentials like writes do: websocket upgrades, the event
+// streams at /events and /ns/{ns}/events, long polls of /watch and gRPC
+// Watch calls.
+func changeStream(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/events", "/watch", pb.KV_Watch_FullMethodName:
+		return true
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return true
+	}
+	ok, _ := path.Match("/ns/*/events", r.URL.Path)
+	return ok
+}
+
+// authHandler attaches the client identity to the request context. Once
+// any authentication method is configured, invalid credentials are
+// rejected with 401, as are anonymous requests for writes or
+// changeStream, and clients without the role requiredRole asks for get 403. Browsers
+// are redirected to the OIDC login instead of a 401, if one is
+// configured.
+func authHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id, err := authenticate(r)
+		if err != nil {
+			slog.InfoContext(r.Context(), "authentication failed", "remote", r.RemoteAddr, "err", err)
+		}
+		need := requiredRole(r)
+		if err != nil || (id == nil && (need > RoleRead || changeStream(r))) {
+			if wantsLogin(r) {
+				http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), 302)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="info-share"`)
+			if basicAuth.enabled() {
+				w.Header().Add("WWW-Authenticate", `Basic realm="info-share"`)
+			}
+			http.Error(w, "missing or invalid credentials", 401)
+			return
+		}
+		if id != nil && id.Prefixes == nil {
+			id.Prefixes = aclRules[id.Subject]
+		}
+		if id != nil && id.Role < need {
+			http.Error(w, "forbidden: needs the "+need.String()+" role", 403)
+			return
+		}
+		if id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
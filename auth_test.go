@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/matst80/go-info-share/infosharepb"
+)
+
+// withAPIKeys configures keys for the duration of the test.
+func withAPIKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	if err := apiKeys.Load(keys, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apiKeys.Load(nil, "") })
+}
+
+func TestAuthHandler(t *testing.T) {
+	withAPIKeys(t, "read:rkey", "write:wkey", "admin:akey")
+	h := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		method, target, key string
+		want                int
+	}{
+		{"GET", "/get?key=a", "", 200},
+		{"GET", "/ns/a/kv/events", "", 200},
+		{"POST", "/set?key=a&value=b", "", 401},
+		{"GET", "/set?key=a&value=b", "", 401},
+		{"GET", "/events", "", 401},
+		{"GET", "/ns/a/events", "", 401},
+		{"GET", "/watch?key=a", "", 401},
+		{"GET", "/watch?key=a", "rkey", 200},
+		{"GET", "/events", "bad", 401},
+		{"POST", pb.KV_Watch_FullMethodName, "", 401},
+		{"POST", pb.KV_Watch_FullMethodName, "rkey", 200},
+		{"POST", pb.KV_Get_FullMethodName, "", 200},
+		{"GET", "/events", "rkey", 200},
+		{"POST", "/set?key=a&value=b", "rkey", 403},
+		{"POST", "/set?key=a&value=b", "wkey", 200},
+		{"GET", "/backup", "wkey", 403},
+		{"GET", "/backup", "akey", 200},
+		{"PUT", "/scripts?name=s", "wkey", 403},
+		{"GET", "/scripts", "wkey", 200},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.key != "" {
+			r.Header.Set("X-API-Key", tc.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s with key %q: status %d, want %d", tc.method, tc.target, tc.key, w.Code, tc.want)
+		}
+	}
+}
+
+func TestAuthHandlerWebsocket(t *testing.T) {
+	withAPIKeys(t, "write:wkey")
+	h := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Errorf("anonymous upgrade: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	h := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, target := range []string{"/set?key=a&value=b", "/events", "/backup"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", target, nil))
+		if w.Code != 200 {
+			t.Errorf("POST %s without auth configured: status %d, want 200", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Dump returns a copy of every entry in the store, including metadata.
+func (k *KVStore) Dump() map[string]Entry {
+	now := time.Now()
+	k.mu.RLock()
+	data := make(map[string]Entry, len(k.data))
+	for key, e := range k.data {
+		if !e.expired(now) {
+			data[key] = e
+		}
+	}
+	k.mu.RUnlock()
+	return data
+}
+
+// Restore loads data into the store. With merge set, existing keys that
+// are not part of data are kept; otherwise the store is replaced. Every
+// key that changes is recorded in its history and the audit log, with
+// the client found in ctx, and broadcast to subscribers. Restore returns
+// the number of changed keys. Set hooks do not run: a backup is loaded
+// as it was taken, its values having passed the hooks when first set,
+// and only administrators may restore.
+func (k *KVStore) Restore(ctx context.Context, data map[string]Entry, merge bool) (int, error) {
+	now := time.Now()
+	origin := subject(ctx)
+	if origin == "" {
+		origin = remoteAddr(ctx)
+	}
+	var cs []Change
+	k.mu.Lock()
+	prev := k.data
+	next := make(map[string]Entry, len(prev)+len(data))
+	if merge {
+		for key, e := range prev {
+			next[key] = e
+		}
+	} else {
+		for key := range prev {
+			if _, ok := data[key]; !ok {
+				cs = append(cs, Change{Op: "delete", Key: key, Time: now})
+			}
+		}
+	}
+	for key, e := range data {
+		if old, ok := prev[key]; ok && sameEntry(old, e) {
+			e.Rev = old.Rev
+		} else {
+			cs = append(cs, Change{Op: "set", Key: key, Entry: e, Time: now})
+		}
+		next[key] = e
+	}
+	seq := k.seq
+	for i := range cs {
+		seq++
+		c := &cs[i]
+		c.Rev, c.Origin = seq, origin
+		if old, ok := prev[c.Key]; ok && !old.expired(now) {
+			c.Old = &old
+		}
+		if c.Op == "set" {
+			c.Entry.Rev = seq
+			next[c.Key] = c.Entry
+		}
+	}
+	if k.storage != nil {
+		if err := k.storage.Save(next); err != nil {
+			k.mu.Unlock()
+			return 0, err
+		}
+		if rs, ok := k.storage.(RevisionStore); ok {
+			if err := rs.SetRevision(seq); err != nil {
+				slog.Error("store revision after restore failed", "rev", seq, "err", err)
+			}
+		}
+		// Instances sharing the backend only learn of changes published
+		// to them.
+		if feed, ok := k.storage.(ChangeFeed); ok {
+			if err := feed.Publish(cs); err != nil {
+				slog.Error("publish restored changes failed", "changes", len(cs), "err", err)
+			}
+		}
+	}
+	k.data = next
+	k.recount()
+	k.reindex()
+	for _, c := range cs {
+		k.seq = c.Rev
+		old, existed := prev[c.Key]
+		k.record(c)
+		k.auditChange(ctx, c, old, existed)
+	}
+	k.seq = seq
+	k.unlockSending()
+	defer k.sendMu.Unlock()
+	for _, c := range cs {
+		k.broadcast(c.event())
+	}
+	return len(cs), nil
+}
+
+// sameEntry reports whether restoring e over old leaves the key as it
+// is.
+func sameEntry(old, e Entry) bool {
+	return old.Value == e.Value && old.Type == e.Type && old.ContentType == e.ContentType && old.ExpiresAt.Equal(e.ExpiresAt)
+}
+
+func (kv *KVStore) backupHandler(w http.ResponseWriter, r *http.Request) {
+	data := kv.Dump()
+	name := "info-share-" + time.Now().UTC().Format("20060102-150405") + ".json"
+	var out io.Writer = w
+	if r.URL.Query().Get("format") == "gzip" {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".gz"))
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	if err := json.NewEncoder(out).Encode(data); err != nil {
+		slog.ErrorContext(r.Context(), "backup failed", "err", err)
+	}
+}
+
+func (kv *KVStore) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "replace"
+	}
+	if mode != "replace" && mode != "merge" {
+		http.Error(w, "mode must be replace or merge", 400)
+		return
+	}
+	body := bufio.NewReader(r.Body)
+	var in io.Reader = body
+	// Accept gzip dumps whether or not the client labelled them.
+	if magic, _ := body.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "invalid gzip data", 400)
+			return
+		}
+		defer gz.Close()
+		in = gz
+	}
+	data := make(map[string]Entry)
+	if err := json.NewDecoder(in).Decode(&data); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	n, err := kv.Restore(r.Context(), data, mode == "merge")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "restore failed", "err", err)
+		http.Error(w, "failed to persist restored data", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"keys": len(data), "changed": n})
+}
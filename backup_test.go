@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+)
+
+// feedStorage is an in-memory backend shared with other instances, which
+// records what it publishes to them.
+type feedStorage struct {
+	data      map[string]Entry
+	published []Change
+}
+
+func (s *feedStorage) Load() (map[string]Entry, error) { return maps.Clone(s.data), nil }
+
+func (s *feedStorage) Save(data map[string]Entry) error {
+	s.data = maps.Clone(data)
+	return nil
+}
+
+func (s *feedStorage) AppendChange(c Change) error {
+	if c.Op == "set" {
+		s.data[c.Key] = c.Entry
+	} else {
+		delete(s.data, c.Key)
+	}
+	s.published = append(s.published, c)
+	return nil
+}
+
+func (s *feedStorage) Watch(fn func(Change)) error { return nil }
+
+func (s *feedStorage) Publish(cs []Change) error {
+	s.published = append(s.published, cs...)
+	return nil
+}
+
+func TestRestore(t *testing.T) {
+	st := &feedStorage{data: make(map[string]Entry)}
+	k, err := NewKVStore(st, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.SetReplaySize(10)
+	ctx := context.Background()
+	k.Set(ctx, "keep", "1")
+	k.Set(ctx, "gone", "2")
+	k.Set(ctx, "same", "3")
+	st.published = nil
+
+	n, err := k.Restore(ctx, map[string]Entry{
+		"keep": {Value: "changed"},
+		"same": {Value: "3"},
+		"new":  {Value: "4", ContentType: "text/plain"},
+	}, false)
+	if err != nil || n != 3 {
+		t.Fatalf("Restore = %d, %v; want 3 changes", n, err)
+	}
+	if _, ok := k.Get("gone"); ok {
+		t.Error("replace-mode restore kept a key missing from the backup")
+	}
+	if v, _ := k.Get("keep"); v != "changed" {
+		t.Errorf("keep = %q, want changed", v)
+	}
+	if e, _ := k.GetEntry("same"); e.Rev != 3 {
+		t.Errorf("unchanged key got revision %d, want 3", e.Rev)
+	}
+	if k.Seq() != 6 {
+		t.Errorf("seq = %d, want 6", k.Seq())
+	}
+	if len(st.published) != 3 {
+		t.Errorf("published %d changes to other instances, want 3", len(st.published))
+	}
+	if _, ok := st.data["gone"]; ok {
+		t.Error("backend still holds a key the restore deleted")
+	}
+
+	k.connMu.Lock()
+	evs, _ := k.replay.since(3)
+	k.connMu.Unlock()
+	if len(evs) != 3 {
+		t.Errorf("broadcast %d restored changes, want 3", len(evs))
+	}
+	if h := k.History("keep"); len(h) != 2 {
+		t.Errorf("history of keep has %d versions, want 2", len(h))
+	}
+}
+
+func TestRestoreMerge(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	k.Set(ctx, "a", "1")
+	if _, err := k.Restore(ctx, map[string]Entry{"b": {Value: "2"}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Get("a"); !ok {
+		t.Error("merge restore removed a key missing from the backup")
+	}
+	if v, _ := k.Get("b"); v != "2" {
+		t.Errorf("b = %q, want 2", v)
+	}
+}
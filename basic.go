@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// basicUsers holds the username/password pairs accepted with HTTP Basic
+// authentication and the users' roles. Passwords are configured as bcrypt hashes; since
+// bcrypt is deliberately slow, a digest of the last password that
+// matched is remembered per user.
+type basicUsers struct {
+	users atomic.Pointer[map[string]basicUser]
+
+	mu       sync.Mutex
+	verified map[string][32]byte
+}
+
+type basicUser struct {
+	hash []byte
+	role Role
+}
+
+// basicAuth is the user list used for all routes.
+var basicAuth = &basicUsers{}
+
+// Load replaces the users with entries of the form user:bcrypt-hash, as
+// written by `htpasswd -nbB`, optionally followed by :role.
+func (u *basicUsers) Load(entries []string) error {
+	m := make(map[string]basicUser, len(entries))
+	for _, e := range entries {
+		parts := strings.Split(e, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return fmt.Errorf("basic auth entry %q is not user:hash[:role]", e)
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return fmt.Errorf("basic auth user %s: %w", parts[0], err)
+		}
+		bu := basicUser{hash: []byte(parts[1]), role: defaultRole}
+		if len(parts) == 3 {
+			role, err := parseRole(parts[2])
+			if err != nil {
+				return fmt.Errorf("basic auth user %s: %w", parts[0], err)
+			}
+			bu.role = role
+		}
+		m[parts[0]] = bu
+	}
+	u.users.Store(&m)
+	u.mu.Lock()
+	u.verified = make(map[string][32]byte)
+	u.mu.Unlock()
+	return nil
+}
+
+// enabled reports whether any users are configured.
+func (u *basicUsers) enabled() bool {
+	m := u.users.Load()
+	return m != nil && len(*m) > 0
+}
+
+var errBadPassword = errors.New("invalid username or password")
+
+// Verify checks user and password and returns the user's identity.
+func (u *basicUsers) Verify(user, password string) (*Identity, error) {
+	m := u.users.Load()
+	if m == nil {
+		return nil, errBadPassword
+	}
+	bu, ok := (*m)[user]
+	if !ok {
+		return nil, errBadPassword
+	}
+	sum := sha256.Sum256([]byte(password))
+	u.mu.Lock()
+	cached, hit := u.verified[user]
+	u.mu.Unlock()
+	if !hit || cached != sum {
+		if bcrypt.CompareHashAndPassword(bu.hash, []byte(password)) != nil {
+			return nil, errBadPassword
+		}
+		u.mu.Lock()
+		u.verified[user] = sum
+		u.mu.Unlock()
+	}
+	return &Identity{Subject: user, Method: "basic", Role: bu.role}, nil
+}
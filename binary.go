@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// binary reports whether e holds a value that should not be embedded in
+// JSON as a plain string, either because its content type is not textual
+// or because it is not valid UTF-8.
+func (e Entry) binary() bool {
+	if !utf8.ValidString(e.Value) {
+		return true
+	}
+	if e.ContentType == "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(e.ContentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		mt == "application/json", strings.HasSuffix(mt, "+json"),
+		mt == "application/xml", strings.HasSuffix(mt, "+xml"),
+		mt == "application/javascript", mt == "application/x-www-form-urlencoded":
+		return false
+	}
+	return true
+}
+
+// jsonValue returns the value as it is shown in JSON responses: base64
+// encoded for binary entries, verbatim otherwise.
+func (e Entry) jsonValue() (value, encoding string) {
+	if e.binary() {
+		return base64.StdEncoding.EncodeToString([]byte(e.Value)), "base64"
+	}
+	return e.Value, ""
+}
+
+// contentType returns the stored content type, defaulting to plain text.
+func (e Entry) contentType() string {
+	if e.ContentType == "" {
+		return "text/plain; charset=utf-8"
+	}
+	return e.ContentType
+}
+
+// MarshalJSON base64 encodes values that are not valid UTF-8 so binary
+// data survives JSON based persistence and backups unchanged.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type plain Entry
+	out := struct {
+		plain
+		Value    string `json:"value"`
+		Encoding string `json:"encoding,omitempty"`
+	}{plain: plain(e), Value: e.Value}
+	if !utf8.ValidString(e.Value) {
+		out.Value = base64.StdEncoding.EncodeToString([]byte(e.Value))
+		out.Encoding = "base64"
+	}
+	return json.Marshal(out)
+}
+
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	type plain Entry
+	var in struct {
+		plain
+		Value    string `json:"value"`
+		Encoding string `json:"encoding"`
+	}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	*e = Entry(in.plain)
+	e.Value = in.Value
+	if in.Encoding == "base64" {
+		raw, err := base64.StdEncoding.DecodeString(in.Value)
+		if err != nil {
+			return err
+		}
+		e.Value = string(raw)
+	}
+	return nil
+}
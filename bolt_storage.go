@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("kv")
+
+// boltRevision is the key of the store revision in the boltMeta bucket.
+var (
+	boltMeta     = []byte("meta")
+	boltRevision = []byte("revision")
+)
+
+// BoltStorage persists entries in a bbolt database, writing only the
+// changed key on every mutation.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage opens (or creates) the bbolt database at path.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltMeta); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (s *BoltStorage) Load() (map[string]Entry, error) {
+	data := make(map[string]Entry)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("decode %q: %w", k, err)
+			}
+			data[string(k)] = e
+			return nil
+		})
+	})
+	return data, err
+}
+
+func (s *BoltStorage) Save(data map[string]Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(boltBucket)
+		if err != nil {
+			return err
+		}
+		for k, e := range data {
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) AppendChange(c Change) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		switch c.Op {
+		case "set":
+			v, err := json.Marshal(c.Entry)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(c.Key), v); err != nil {
+				return err
+			}
+		case "delete", "expire", "evict":
+			if err := b.Delete([]byte(c.Key)); err != nil {
+				return err
+			}
+		}
+		return putBoltRevision(tx, c.Rev)
+	})
+}
+
+func (s *BoltStorage) Revision() (uint64, error) {
+	var rev uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltMeta).Get(boltRevision); len(v) == 8 {
+			rev = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return rev, err
+}
+
+func (s *BoltStorage) SetRevision(rev uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error { return putBoltRevision(tx, rev) })
+}
+
+func putBoltRevision(tx *bolt.Tx, rev uint64) error {
+	return tx.Bucket(boltMeta).Put(boltRevision, binary.BigEndian.AppendUint64(nil, rev))
+}
+
+// Close releases the database file lock.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetBulk stores every pair in values under a single lock acquisition
+// and notifies subscribers with one batched message. The set hooks of
+// the keys run as for single sets. Nothing is stored if a hook rejects
+// a value or any pair exceeds the configured limits.
+func (k *KVStore) SetBulk(ctx context.Context, values map[string]string, ttl time.Duration) error {
+	k.mu.Lock()
+	r := k.newScriptRun(ctx, "")
+	for key, value := range values {
+		e := newEntry(value, ttl)
+		r.write(key, &e)
+	}
+	err := r.runHooks()
+	if err == nil {
+		err = r.check(nil)
+	}
+	if err != nil {
+		k.mu.Unlock()
+		return err
+	}
+	cs := r.commit()
+	k.unlockSending()
+	defer k.sendMu.Unlock()
+	k.broadcastBatch(cs)
+	return nil
+}
+
+// GetBulk returns the values of keys under a single lock acquisition,
+// along with the keys that do not exist. Binary values are base64
+// encoded.
+func (k *KVStore) GetBulk(keys []string) (map[string]string, []string) {
+	now := time.Now()
+	values := make(map[string]string, len(keys))
+	missing := make([]string, 0)
+	k.mu.RLock()
+	for _, key := range keys {
+		e, ok := k.data[key]
+		if !ok || e.expired(now) {
+			missing = append(missing, key)
+			continue
+		}
+		values[key], _ = e.jsonValue()
+	}
+	k.mu.RUnlock()
+	return values, missing
+}
+
+func (kv *KVStore) setBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, "invalid ttl", 400)
+		return
+	}
+	var values map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&values); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	for key := range values {
+		if !validName(key) {
+			http.Error(w, "invalid key", 400)
+			return
+		}
+		if !allowKeys(w, r, key) {
+			return
+		}
+	}
+	if err := kv.SetBulk(r.Context(), values, ttl); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
+
+func (kv *KVStore) getBulkHandler(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if r.Method == "POST" {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&keys); err != nil {
+			http.Error(w, "invalid json", 400)
+			return
+		}
+	} else if s := r.URL.Query().Get("keys"); s != "" {
+		keys = strings.Split(s, ",")
+	}
+	if len(keys) == 0 {
+		http.Error(w, "missing keys", 400)
+		return
+	}
+	values, missing := kv.GetBulk(keys)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"values": values, "missing": missing})
+}
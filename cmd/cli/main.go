@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func main() {
+	var baseURL, apiKey string
+	flag.StringVar(&baseURL, "url", "", "Base URL of the info server")
+	flag.StringVar(&apiKey, "api-key", os.Getenv("INFO_API_KEY"), "API key sent with the request")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("usage: cli [--url BASE_URL] [--api-key KEY] <key> <value>")
+		os.Exit(1)
+	}
+
+	key := args[0]
+	value := args[1]
+
+	if baseURL == "" {
+		baseURL = os.Getenv("INFO_SERVER_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+	}
+
+	form := url.Values{"key": {key}, "value": {value}}
+	req, err := http.NewRequest("POST", baseURL+"/set", strings.NewReader(form.Encode()))
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("error reading response:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(body))
+}
\ No newline at end of file
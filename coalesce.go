@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer holds back events that carry the full state of a key for a
+// window and then sends only the latest one per key. It is enabled with
+// --coalesce-window for chatty publishers.
+type coalescer struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	order  []string // internal keys in the order they first changed
+	latest map[string]Event
+	timer  *time.Timer
+}
+
+// SetCoalesceWindow enables coalescing of key events within d; zero
+// sends every event right away.
+func (k *KVStore) SetCoalesceWindow(d time.Duration) {
+	if d > 0 {
+		k.coalesce = &coalescer{window: d, latest: make(map[string]Event)}
+	}
+}
+
+// coalescible reports whether ev replaces everything earlier events said
+// about its key. Deltas such as "rpush" and key-less events are not.
+func coalescible(ev Event) bool {
+	switch ev.Type {
+	case "set", "delete", "expire", "evict":
+		return true
+	}
+	return false
+}
+
+// send writes ev to the subscribers of ev.Namespace. With coalescing on,
+// key events wait for the window to end; any other event first flushes
+// them, so that subscribers see changes in order. Sinks, such as the
+// Kafka feed and webhooks, are passed every change at once.
+func (k *KVStore) send(ev Event) {
+	k.connMu.Lock()
+	k.notifySinks(ev)
+	k.connMu.Unlock()
+	co := k.coalesce
+	if co == nil {
+		k.fanOut(ev)
+		return
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if !coalescible(ev) {
+		k.flushLocked()
+		k.fanOut(ev)
+		return
+	}
+	ik := nsKey(ev.Namespace, ev.Key)
+	if prev, ok := co.latest[ik]; ok {
+		// Keep the value from before the window as the old value.
+		ev.Old = prev.Old
+		broadcastsCoalesced.Add(1)
+	} else {
+		co.order = append(co.order, ik)
+	}
+	co.latest[ik] = ev
+	if co.timer == nil {
+		co.timer = time.AfterFunc(co.window, func() {
+			co.mu.Lock()
+			defer co.mu.Unlock()
+			k.flushLocked()
+		})
+	}
+}
+
+// flushLocked sends the held back events, one event or a "batch" per
+// namespace. Callers must hold k.coalesce.mu.
+func (k *KVStore) flushLocked() {
+	co := k.coalesce
+	if co.timer != nil {
+		co.timer.Stop()
+		co.timer = nil
+	}
+	if len(co.order) == 0 {
+		return
+	}
+	byNS := make(map[string][]Event)
+	var namespaces []string
+	for _, ik := range co.order {
+		ev := co.latest[ik]
+		if _, ok := byNS[ev.Namespace]; !ok {
+			namespaces = append(namespaces, ev.Namespace)
+		}
+		byNS[ev.Namespace] = append(byNS[ev.Namespace], ev)
+	}
+	co.order = co.order[:0]
+	clear(co.latest)
+	for _, ns := range namespaces {
+		if evs := byNS[ns]; len(evs) == 1 {
+			k.fanOut(evs[0])
+		} else {
+			k.fanOut(Event{Type: "batch", Namespace: ns, Events: evs})
+		}
+	}
+}
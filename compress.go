@@ -0,0 +1,92 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// compressWriter compresses the response body with the negotiated
+// encoding once the handler starts writing, unless the handler already
+// produced compressed content.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	w        io.WriteCloser
+	started  bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if !cw.started {
+		cw.started = true
+		h := cw.Header()
+		if code != http.StatusNoContent && code != http.StatusNotModified &&
+			h.Get("Content-Encoding") == "" && h.Get("Content-Type") != "application/gzip" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if cw.encoding == "gzip" {
+				cw.w = gzip.NewWriter(cw.ResponseWriter)
+			} else {
+				cw.w = zlib.NewWriter(cw.ResponseWriter)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if neither is acceptable.
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressed wraps h to compress responses for clients that send a
+// matching Accept-Encoding. It is meant for endpoints whose responses
+// grow with the store, such as /getall and /backup.
+func compressed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == "HEAD" {
+			h(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		h(cw, r)
+		if cw.w != nil {
+			cw.w.Close()
+		}
+	}
+}
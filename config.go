@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables that override flags, e.g.
+// INFO_SHARE_STORAGE=bolt for --storage=bolt.
+const envPrefix = "INFO_SHARE_"
+
+// applyConfig fills in flags that were not given on the command line,
+// first from the environment and then from the YAML config file, if
+// any. Config keys are flag names; nested maps are joined with "-", so
+// `backup: {bucket: b}` sets --backup-bucket. Lists set repeatable flags
+// once per item.
+func applyConfig(file string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok && !set[f.Name] && err == nil {
+			if err = f.Value.Set(v); err != nil {
+				err = fmt.Errorf("%s: %w", env, err)
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil || file == "" {
+		return err
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]any
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	values := make(map[string]any)
+	flatten("", cfg, values)
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("%s: unknown setting %q", file, name)
+		}
+		if set[name] {
+			continue
+		}
+		items, ok := v.([]any)
+		if !ok {
+			items = []any{v}
+		}
+		for _, item := range items {
+			if err := f.Value.Set(fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("%s: %s: %w", file, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func flatten(prefix string, m map[string]any, out map[string]any) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "-" + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flatten(k, sub, out)
+			continue
+		}
+		out[k] = v
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// corsConfig controls the CORS headers sent on every route.
+type corsConfig struct {
+	Methods     string
+	Headers     string
+	Expose      string
+	Credentials bool
+
+	// origins lists the allowed origins; "*" allows any origin. It can be
+	// swapped while serving, e.g. on a config reload.
+	origins atomic.Pointer[[]string]
+}
+
+// SetOrigins replaces the allowed origins.
+func (c *corsConfig) SetOrigins(origins []string) {
+	c.origins.Store(&origins)
+}
+
+func (c *corsConfig) allowAny() bool {
+	return slices.Contains(*c.origins.Load(), "*")
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin,
+// or "" if the origin is not allowed.
+func (c *corsConfig) allowOrigin(origin string) string {
+	origins := *c.origins.Load()
+	if slices.Contains(origins, "*") {
+		if c.Credentials && origin != "" {
+			// Browsers reject "*" on credentialed requests.
+			return origin
+		}
+		return "*"
+	}
+	if origin != "" && slices.Contains(origins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// checkOrigin applies the same origin policy to websocket upgrades.
+// Requests without an Origin header are not from browsers and are
+// always accepted.
+func (c *corsConfig) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || c.allowOrigin(origin) != ""
+}
+
+// handler adds CORS headers to every response from h and answers OPTIONS
+// preflight requests itself.
+func (c *corsConfig) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		if !c.allowAny() || c.Credentials {
+			hdr.Add("Vary", "Origin")
+		}
+		if allow := c.allowOrigin(r.Header.Get("Origin")); allow != "" {
+			hdr.Set("Access-Control-Allow-Origin", allow)
+			hdr.Set("Access-Control-Allow-Methods", c.Methods)
+			hdr.Set("Access-Control-Allow-Headers", c.Headers)
+			if c.Expose != "" {
+				hdr.Set("Access-Control-Expose-Headers", c.Expose)
+			}
+			if c.Credentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(204)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
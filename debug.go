@@ -0,0 +1,58 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+)
+
+// Counters published under info_share in /debug/vars.
+var (
+	broadcastsSent  = new(expvar.Int)
+	broadcastErrors = new(expvar.Int)
+	wsRejected      = new(expvar.Int)
+	wsSlowConsumers = new(expvar.Int)
+	// broadcastsCoalesced counts key events replaced by a later one
+	// within --coalesce-window.
+	broadcastsCoalesced = new(expvar.Int)
+	// sinkDropped counts change events a sink such as the MQTT bridge
+	// fell too far behind to take.
+	sinkDropped = new(expvar.Int)
+)
+
+// publishVars registers the runtime and store statistics of kv with
+// expvar, next to the default cmdline and memstats variables.
+func publishVars(kv *KVStore) {
+	m := expvar.NewMap("info_share")
+	m.Set("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	m.Set("connections", expvar.Func(func() any { return kv.Stats().Connections }))
+	m.Set("keys", expvar.Func(func() any { return kv.Stats().Keys }))
+	m.Set("bytes", expvar.Func(func() any { return kv.Stats().Bytes }))
+	m.Set("revision", expvar.Func(func() any { return kv.Stats().Revision }))
+	m.Set("broadcasts_pending", expvar.Func(func() any { return kv.Queued() }))
+	m.Set("broadcasts_sent", broadcastsSent)
+	m.Set("broadcast_errors", broadcastErrors)
+	m.Set("broadcasts_coalesced", broadcastsCoalesced)
+	m.Set("sink_dropped", sinkDropped)
+	m.Set("ws_rejected", wsRejected)
+	m.Set("ws_slow_consumers", wsSlowConsumers)
+}
+
+// serveDebug serves the debug handlers on addr, typically a loopback
+// address that is not exposed alongside the API.
+func serveDebug(addr string) {
+	slog.Info("debug server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, http.DefaultServeMux); err != nil {
+		slog.Error("debug server failed", "err", err)
+	}
+}
+
+// mountDebug makes the handler for path, /debug/pprof/ or /debug/vars,
+// available on the API mux. The net/http/pprof and expvar imports
+// register them on http.DefaultServeMux, which is why the API routes use
+// a mux of their own.
+func mountDebug(mux *http.ServeMux, path string) {
+	mux.Handle(path, http.DefaultServeMux)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// electionPrefix is the key prefix under which elections keep the lease
+// of their leader.
+const electionPrefix = "election/"
+
+// Leader describes the current leader of an election.
+type Leader struct {
+	Election string `json:"election"`
+	Lease
+}
+
+// announceLeader follows the lease keys of elections and sends a
+// "leader" event for the election key, with the new leader as the value
+// or an empty value when the election has none, whenever leadership
+// changes. Renewals by the
+// current leader are not announced.
+func (k *KVStore) announceLeader(ev Event) {
+	name, ok := strings.CutPrefix(ev.Key, electionPrefix)
+	if !ok || ev.Namespace != "" || ev.Type == "batch" {
+		return
+	}
+	var owner string
+	var token uint64
+	if ev.Type == "set" {
+		var l Lease
+		if json.Unmarshal([]byte(ev.Value), &l) != nil {
+			return
+		}
+		owner, token = l.Owner, l.Token
+	}
+	k.leaderMu.Lock()
+	changed := k.leaders[name] != token
+	if token == 0 {
+		delete(k.leaders, name)
+	} else {
+		k.leaders[name] = token
+	}
+	k.leaderMu.Unlock()
+	if changed {
+		k.send(Event{Type: "leader", Key: ev.Key, Value: owner})
+	}
+}
+
+// electionHandler serves /election/campaign, /election/observe and
+// /election/resign for the election given by ?name=. A campaign by the
+// current leader with its token renews the lease; any other campaign
+// wins only if the election has no leader.
+func (kv *KVStore) electionHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if !validName(name) {
+		http.Error(w, "missing name", 400)
+		return
+	}
+	key := electionPrefix + name
+	if !allowKeys(w, r, key) {
+		return
+	}
+	var token uint64
+	if s := q.Get("token"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid token", 400)
+			return
+		}
+		token = n
+	}
+	var l Lease
+	var err error
+	switch r.URL.Path {
+	case "/election/observe":
+		e, _ := kv.GetEntry(key)
+		var ok bool
+		if l, ok = lease(e); !ok {
+			http.Error(w, "no leader", 404)
+			return
+		}
+	case "/election/campaign":
+		ttl, perr := parseTTL(q.Get("ttl"))
+		if perr != nil || ttl < 0 {
+			http.Error(w, "invalid ttl", 400)
+			return
+		}
+		if token != 0 {
+			l, err = kv.RenewLock(r.Context(), key, token, ttl)
+			break
+		}
+		candidate := q.Get("candidate")
+		if candidate == "" {
+			candidate = subject(r.Context())
+		}
+		if candidate == "" {
+			candidate = r.RemoteAddr
+		}
+		if ttl == 0 {
+			ttl = defaultLeaseTTL
+		}
+		l, err = kv.AcquireLock(r.Context(), key, candidate, ttl)
+		if errors.Is(err, errConflict) {
+			// Losing candidates learn who leads instead of an error.
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(409)
+			json.NewEncoder(w).Encode(Leader{Election: name, Lease: l})
+			return
+		}
+	case "/election/resign":
+		if err = kv.ReleaseLock(r.Context(), key, token); err == nil {
+			fmt.Fprint(w, "ok")
+			return
+		}
+	}
+	if errors.Is(err, errNotHeld) {
+		http.Error(w, "not the leader", 409)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Leader{Election: name, Lease: l})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// connect connects a client to the default namespace of k and returns the
+// events broadcast to it.
+func connect(t *testing.T, k *KVStore) <-chan Event {
+	t.Helper()
+	events := make(chan Event, 100)
+	c := &wsClient{conn: newStreamConn(httpAddr("test"), func(data []byte) error {
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return err
+		}
+		events <- ev
+		return nil
+	})}
+	k.addConn(c)
+	t.Cleanup(func() { k.removeConn(c) })
+	return events
+}
+
+// nextLeader returns the next "leader" event, skipping the changes of
+// the lease key.
+func nextLeader(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == "leader" {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("no leader event")
+		}
+	}
+}
+
+// campaign runs an election request for name and decodes the leader it
+// returns.
+func campaign(t *testing.T, k *KVStore, path, query string) (int, Leader) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	k.electionHandler(w, httptest.NewRequest("POST", path+"?name=db&"+query, nil))
+	var l Leader
+	json.Unmarshal(w.Body.Bytes(), &l)
+	return w.Code, l
+}
+
+func TestElection(t *testing.T) {
+	k := newTestStore(t)
+	events := connect(t, k)
+
+	code, a := campaign(t, k, "/election/campaign", "candidate=a&ttl=1m")
+	if code != 200 || a.Election != "db" || a.Owner != "a" {
+		t.Fatalf("campaign of a = %d %+v, want a as the leader", code, a)
+	}
+	if ev := nextLeader(t, events); ev.Key != "election/db" || ev.Value != "a" {
+		t.Errorf("leader event = %+v, want a for election/db", ev)
+	}
+	if code, l := campaign(t, k, "/election/campaign", "candidate=b"); code != 409 || l.Owner != "a" {
+		t.Errorf("campaign of b = %d %+v, want 409 naming a", code, l)
+	}
+	if code, l := campaign(t, k, "/election/observe", ""); code != 200 || l.Owner != "a" || l.Token != a.Token {
+		t.Errorf("observe = %d %+v, want the lease of a", code, l)
+	}
+	if code, _ := campaign(t, k, "/election/campaign", fmt.Sprint("token=", a.Token)); code != 200 {
+		t.Errorf("renewal by the leader: status %d, want 200", code)
+	}
+	if code, _ := campaign(t, k, "/election/resign", fmt.Sprint("token=", a.Token+1)); code != 409 {
+		t.Errorf("resign with a wrong token: status %d, want 409", code)
+	}
+	if code, _ := campaign(t, k, "/election/resign", fmt.Sprint("token=", a.Token)); code != 200 {
+		t.Errorf("resign by the leader: status %d, want 200", code)
+	}
+	// The renewal is not announced, so the next event is the resignation.
+	if ev := nextLeader(t, events); ev.Value != "" {
+		t.Errorf("leader event after resigning = %+v, want no leader", ev)
+	}
+	if code, _ := campaign(t, k, "/election/observe", ""); code != 404 {
+		t.Errorf("observe without a leader: status %d, want 404", code)
+	}
+}
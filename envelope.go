@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// protocolV2 is the websocket subprotocol for versioned envelopes.
+// Clients that do not ask for it keep receiving plain events.
+// protocolCBOR carries the same envelopes, and the client's messages,
+// as CBOR in binary frames for deployments where JSON is a measurable
+// cost.
+const (
+	protocolV2   = "info-share.v2"
+	protocolCBOR = "info-share.v2+cbor"
+)
+
+// wireFormat is the message format a websocket client negotiated.
+type wireFormat int
+
+const (
+	formatJSON    wireFormat = iota // plain events as JSON text
+	formatV2                        // envelopes as JSON text
+	formatCBOR                      // envelopes as CBOR
+	formatSSE                       // plain events as Server-Sent Events
+	formatProto                     // gRPC Watch events as protobuf
+	formatJSONRPC                   // envelopes as JSON-RPC notifications
+	numFormats
+)
+
+// negotiatedFormat returns the wire format of the subprotocol conn
+// agreed on during the upgrade.
+func negotiatedFormat(conn *websocket.Conn) wireFormat {
+	switch conn.Subprotocol() {
+	case protocolV2:
+		return formatV2
+	case protocolCBOR:
+		return formatCBOR
+	case protocolJSONRPC:
+		return formatJSONRPC
+	}
+	return formatJSON
+}
+
+// cborMode encodes times as RFC 3339 strings, like encoding/json.
+var cborMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+// envelope is an event in the versioned format of protocolV2. Besides
+// the fields of Event it carries the previous value of the key, the
+// revision of the change, when it happened and the subject or address of
+// the client that made it.
+type envelope struct {
+	Version int `json:"version"`
+	Event
+	OldValue  *string    `json:"oldValue,omitempty"`
+	Revision  uint64     `json:"revision,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	Origin    string     `json:"origin,omitempty"`
+	Events    []envelope `json:"events,omitempty"`
+	// Seq hides the "seq" of Event, which is Revision here.
+	Seq *struct{} `json:"seq,omitempty"`
+}
+
+// newEnvelope wraps ev, and the events of a batch, in envelopes.
+func newEnvelope(ev Event) envelope {
+	env := envelope{Version: 2, Event: ev, OldValue: ev.Old, Revision: ev.Rev, Timestamp: ev.Time, Origin: ev.Origin}
+	if env.Timestamp.IsZero() {
+		env.Timestamp = time.Now()
+	}
+	for _, e := range ev.Events {
+		env.Events = append(env.Events, newEnvelope(e))
+	}
+	return env
+}
+
+// encode marshals ev in the format negotiated by client c.
+func (c *wsClient) encode(ev Event) []byte {
+	switch c.format {
+	case formatJSON, formatProto:
+		return c.marshal(ev)
+	case formatSSE:
+		return encodeSSE(ev)
+	}
+	return c.marshal(newEnvelope(ev))
+}
+
+// marshal encodes v, any message for client c, in its wire format.
+func (c *wsClient) marshal(v any) []byte {
+	var data []byte
+	switch c.format {
+	case formatCBOR:
+		data, _ = cborMode.Marshal(v)
+	case formatSSE:
+		data, _ = json.Marshal(v)
+		data = sseFrame(0, data)
+	case formatProto:
+		data, _ = proto.Marshal(protoMessage(v))
+	case formatJSONRPC:
+		data, _ = json.Marshal(rpcFrame(v))
+	default:
+		data, _ = json.Marshal(v)
+	}
+	return data
+}
+
+// unmarshal decodes a message read from client c.
+func (c *wsClient) unmarshal(data []byte, v any) error {
+	if c.format == formatCBOR {
+		return cbor.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// messageType is the websocket frame type of messages for client c.
+func (c *wsClient) messageType() int {
+	if c.format == formatCBOR {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
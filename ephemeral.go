@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// setEphemeral runs an "ephemeral" message: it stores the value under
+// the key and binds the key to the connection of client c. When the
+// connection drops the key is deleted and the deletion is broadcast, as
+// with ZooKeeper ephemeral nodes. The revision is remembered so that the
+// key is only removed while it still holds the value the client wrote.
+func (k *KVStore) setEphemeral(ctx context.Context, c *wsClient, m wsMessage) (uint64, error) {
+	ik, err := c.mayWrite(m.Key)
+	if err != nil {
+		return 0, err
+	}
+	e := newEntry(m.Value, 0)
+	e.ContentType = m.ContentType
+	_, rev, err := k.PutIf(ctx, ik, e, nil)
+	if err != nil {
+		return 0, err
+	}
+	if c.ephemeral == nil {
+		c.ephemeral = make(map[string]uint64)
+	}
+	c.ephemeral[ik] = rev
+	return rev, nil
+}
+
+// dropEphemeral deletes the ephemeral keys of client c that have not
+// been overwritten since.
+func (k *KVStore) dropEphemeral(ctx context.Context, c *wsClient) {
+	for ik, rev := range c.ephemeral {
+		ok, _ := k.DeleteIf(ctx, ik, func(cur Entry, exists bool) bool {
+			return exists && cur.Rev == rev
+		})
+		if ok {
+			slog.DebugContext(ctx, "ephemeral key removed", "key", ik)
+		}
+	}
+}
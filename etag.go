@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errPrecondition is returned when a conditional write finds the key at a
+// different revision than the writer expected.
+var errPrecondition = errors.New("precondition failed")
+
+// precondition decides whether a conditional write may proceed given the
+// current entry and whether it exists.
+type precondition func(cur Entry, exists bool) bool
+
+// etag formats a store or key revision as an entity tag.
+func etag(rev uint64) string {
+	return `"` + strconv.FormatUint(rev, 10) + `"`
+}
+
+// notModified sets the ETag header to tag and, if the request's
+// If-None-Match header matches it, writes 304 Not Modified and returns
+// true.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch turns the request's If-Match header into a precondition, or
+// nil if there is none. "*" requires the key to exist; otherwise its
+// current revision must be listed, as an entity tag or a bare number.
+func ifMatch(r *http.Request) precondition {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return nil
+	}
+	tags := strings.Split(im, ",")
+	return func(cur Entry, exists bool) bool {
+		if !exists {
+			return false
+		}
+		for _, t := range tags {
+			t = strings.TrimSpace(t)
+			if t == "*" || t == etag(cur.Rev) || t == strconv.FormatUint(cur.Rev, 10) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// entryOverhead approximates the per-key bookkeeping cost on top of the
+// key and value bytes.
+const entryOverhead = 64
+
+// evictionSample is the number of keys inspected per eviction. As in
+// Redis, sampling approximates LRU/LFU without maintaining an ordered
+// structure on every read.
+const evictionSample = 16
+
+// keyStats tracks how a key is used for eviction decisions. Fields are
+// updated atomically so reads only need k.mu.RLock.
+type keyStats struct {
+	lastUsed atomic.Int64
+	hits     atomic.Uint64
+}
+
+func (s *keyStats) touch() {
+	s.lastUsed.Store(time.Now().UnixNano())
+	s.hits.Add(1)
+}
+
+func entrySize(key string, e Entry) int64 {
+	return int64(len(key)+len(e.Value)+len(e.ContentType)) + entryOverhead
+}
+
+// apply updates k.data, the memory accounting and the usage statistics
+// for c. Callers must hold k.mu.
+func (k *KVStore) apply(c Change) {
+	if old, ok := k.data[c.Key]; ok {
+		k.bytes -= entrySize(c.Key, old)
+	}
+	applyChange(k.data, c)
+	k.updateIndexes(c)
+	if c.Op == "set" {
+		k.bytes += entrySize(c.Key, c.Entry)
+	}
+	if k.stats == nil {
+		return
+	}
+	if c.Op == "set" {
+		s := k.stats[c.Key]
+		if s == nil {
+			s = new(keyStats)
+			k.stats[c.Key] = s
+		}
+		s.touch()
+		if k.maxMemory > 0 && k.bytes > k.maxMemory {
+			select {
+			case k.evictCh <- struct{}{}:
+			default:
+			}
+		}
+	} else {
+		delete(k.stats, c.Key)
+	}
+}
+
+// recount recomputes the memory accounting after k.data was replaced.
+// Callers must hold k.mu.
+func (k *KVStore) recount() {
+	k.bytes = 0
+	for key, e := range k.data {
+		k.bytes += entrySize(key, e)
+	}
+	for key, h := range k.history {
+		for _, v := range h {
+			k.bytes += versionSize(key, v)
+		}
+	}
+	if k.stats != nil {
+		k.stats = make(map[string]*keyStats, len(k.data))
+		for key := range k.data {
+			s := new(keyStats)
+			s.touch()
+			k.stats[key] = s
+		}
+	}
+}
+
+// MemoryUsage returns the approximate number of bytes held by the store.
+func (k *KVStore) MemoryUsage() int64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.bytes
+}
+
+// StartEviction caps the store at roughly maxBytes. When the cap is
+// exceeded, keys are evicted by policy ("lru" or "lfu") and subscribers
+// receive an "evict" event for each of them.
+func (k *KVStore) StartEviction(maxBytes int64, policy string) error {
+	if policy != "lru" && policy != "lfu" {
+		return fmt.Errorf("unknown eviction policy %q", policy)
+	}
+	k.mu.Lock()
+	k.maxMemory = maxBytes
+	k.policy = policy
+	k.stats = make(map[string]*keyStats)
+	k.evictCh = make(chan struct{}, 1)
+	k.recount()
+	over := k.bytes > k.maxMemory
+	k.mu.Unlock()
+	go k.evictLoop()
+	if over {
+		k.evictCh <- struct{}{}
+	}
+	return nil
+}
+
+func (k *KVStore) evictLoop() {
+	for range k.evictCh {
+		var evicted []Change
+		k.mu.Lock()
+		for k.bytes > k.maxMemory && len(k.data) > 0 {
+			key := k.evictionCandidate()
+			c := Change{Op: "evict", Key: key, Time: time.Now()}
+			k.commit(context.Background(), &c)
+			evicted = append(evicted, c)
+		}
+		k.unlockSending()
+		if len(evicted) > 0 {
+			slog.Info("evicted keys", "count", len(evicted), "max_bytes", k.maxMemory)
+		}
+		for _, c := range evicted {
+			k.broadcast(c.event())
+		}
+		k.sendMu.Unlock()
+	}
+}
+
+// evictionCandidate picks the least recently or least frequently used
+// key among a sample of keys. Callers must hold k.mu.
+func (k *KVStore) evictionCandidate() string {
+	var best string
+	var bestUsed int64
+	var bestHits uint64
+	n := 0
+	for key := range k.data {
+		s := k.stats[key]
+		var used int64
+		var hits uint64
+		if s != nil {
+			used, hits = s.lastUsed.Load(), s.hits.Load()
+		}
+		better := used < bestUsed
+		if k.policy == "lfu" {
+			better = hits < bestHits || hits == bestHits && used < bestUsed
+		}
+		if n == 0 || better {
+			best, bestUsed, bestHits = key, used, hits
+		}
+		if n++; n >= evictionSample {
+			break
+		}
+	}
+	return best
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execValueEnv is the largest value passed in INFO_SHARE_VALUE; larger
+// values are only available on stdin.
+const execValueEnv = 32 << 10
+
+// execOutput is how much of the output of a failed command is logged.
+const execOutput = 4 << 10
+
+// ExecHook is a shell command run for every change to a key matching
+// Pattern, in path.Match syntax like --schema patterns.
+type ExecHook struct {
+	Pattern string
+	Command string
+}
+
+// parseExecHookFlag parses an --exec-hook value of the form
+// pattern command..., e.g. "nginx/* ./render-nginx && nginx -s reload".
+func parseExecHookFlag(s string) (ExecHook, error) {
+	pattern, command, _ := strings.Cut(strings.TrimSpace(s), " ")
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return ExecHook{}, fmt.Errorf("exec hook %q has no command", s)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return ExecHook{}, fmt.Errorf("invalid exec hook pattern %q: %w", pattern, err)
+	}
+	return ExecHook{Pattern: pattern, Command: command}, nil
+}
+
+// StartExecHooks runs hooks for the changes to the keys of namespace ns.
+// The commands of a hook run one at a time, in the order of the
+// changes, through /bin/sh with the value after the change on stdin and
+// the change described in the environment:
+//
+//	INFO_SHARE_EVENT      set, delete, expire, evict, incr, ...
+//	INFO_SHARE_KEY        the key
+//	INFO_SHARE_NAMESPACE  its namespace
+//	INFO_SHARE_REVISION   the revision of the change
+//	INFO_SHARE_ORIGIN     who made it
+//	INFO_SHARE_VALUE      the value, unless removed or over 32 KiB
+//
+// Commands still running after timeout are killed. Failures are logged
+// with the start of their output.
+func (k *KVStore) StartExecHooks(hooks []ExecHook, ns string, timeout time.Duration) {
+	for _, h := range hooks {
+		k.addSink(&sink{
+			name:    "exec " + h.Pattern,
+			deliver: func(ev Event) { k.runExecHook(h, ev, timeout) },
+			match: func(ev Event) bool {
+				ok, _ := path.Match(h.Pattern, ev.Key)
+				return ok && ev.Namespace == ns
+			},
+		})
+	}
+}
+
+func (k *KVStore) runExecHook(h ExecHook, ev Event, timeout time.Duration) {
+	value, _ := k.currentValue(ev)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	cmd.Stdin = bytes.NewReader(value)
+	// Children of the shell may keep the output open after it is killed.
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"INFO_SHARE_EVENT="+ev.Type,
+		"INFO_SHARE_KEY="+ev.Key,
+		"INFO_SHARE_NAMESPACE="+ev.Namespace,
+		"INFO_SHARE_REVISION="+strconv.FormatUint(ev.Rev, 10),
+		"INFO_SHARE_ORIGIN="+ev.Origin,
+	)
+	if len(value) <= execValueEnv && bytes.IndexByte(value, 0) < 0 {
+		cmd.Env = append(cmd.Env, "INFO_SHARE_VALUE="+string(value))
+	}
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("killed after %s", timeout)
+		}
+		slog.Warn("exec hook failed", "pattern", h.Pattern, "key", ev.Key, "rev", ev.Rev, "err", err, "output", string(out[:min(len(out), execOutput)]))
+		return
+	}
+	slog.Debug("exec hook ran", "pattern", h.Pattern, "key", ev.Key, "rev", ev.Rev, "duration", time.Since(start))
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// protocolGraphQL is the websocket subprotocol for GraphQL
+// subscriptions, graphql-transport-ws as spoken by the graphql-ws
+// library and most GraphQL clients.
+const protocolGraphQL = "graphql-transport-ws"
+
+// maxGraphQLRequest caps the size of a GraphQL request body.
+const maxGraphQLRequest = 1 << 20
+
+type gqlNamespaceKey struct{}
+
+type gqlSubKey struct{}
+
+// gqlNamespace returns the namespace a GraphQL request works in.
+func gqlNamespace(ctx context.Context) string {
+	ns, _ := ctx.Value(gqlNamespaceKey{}).(string)
+	return ns
+}
+
+// gqlKeyValue is a key as returned by GraphQL queries and mutations.
+type gqlKeyValue struct {
+	Key         string     `json:"key"`
+	Value       string     `json:"value"`
+	Encoding    string     `json:"encoding"`
+	ContentType string     `json:"contentType"`
+	Type        string     `json:"type"`
+	Revision    uint64     `json:"revision"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+func newGQLKeyValue(key string, e Entry) *gqlKeyValue {
+	kv := &gqlKeyValue{Key: key, ContentType: e.ContentType, Type: e.Type, Revision: e.Rev}
+	kv.Value, kv.Encoding = e.jsonValue()
+	if !e.ExpiresAt.IsZero() {
+		kv.ExpiresAt = &e.ExpiresAt
+	}
+	return kv
+}
+
+// gqlKeyChange is the payload of the keyChanged subscription, taken
+// from the versioned envelope of a change.
+type gqlKeyChange struct {
+	Type      string     `json:"type"`
+	Key       string     `json:"key"`
+	Value     *string    `json:"value"`
+	Encoding  string     `json:"encoding"`
+	OldValue  *string    `json:"oldValue"`
+	Revision  uint64     `json:"revision"`
+	Timestamp *time.Time `json:"timestamp"`
+	Origin    string     `json:"origin"`
+}
+
+func newGQLKeyChange(env envelope) *gqlKeyChange {
+	c := &gqlKeyChange{Type: env.Type, Key: env.Key, Encoding: env.Encoding, OldValue: env.OldValue, Revision: env.Revision, Origin: env.Origin}
+	if env.Value != "" || env.Type == "set" {
+		c.Value = &env.Value
+	}
+	if !env.Timestamp.IsZero() {
+		c.Timestamp = &env.Timestamp
+	}
+	return c
+}
+
+// revisionScalar serializes store revisions, which do not fit the 32-bit
+// GraphQL Int.
+var revisionScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Revision",
+	Description: "A store revision, an unsigned 64-bit integer.",
+	Serialize:   func(v any) any { return v },
+})
+
+// newGraphQLSchema builds the GraphQL schema of kv: queries over keys,
+// set and delete mutations and the keyChanged subscription.
+func newGraphQLSchema(kv *KVStore) (graphql.Schema, error) {
+	keyValue := graphql.NewObject(graphql.ObjectConfig{
+		Name: "KeyValue",
+		Fields: graphql.Fields{
+			"key":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"value":       &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "The value, base64 encoded if encoding is set."},
+			"encoding":    &graphql.Field{Type: graphql.String},
+			"contentType": &graphql.Field{Type: graphql.String},
+			"type":        &graphql.Field{Type: graphql.String, Description: "The value type, such as json or list."},
+			"revision":    &graphql.Field{Type: graphql.NewNonNull(revisionScalar)},
+			"expiresAt":   &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	keyChange := graphql.NewObject(graphql.ObjectConfig{
+		Name: "KeyChange",
+		Fields: graphql.Fields{
+			"type":      &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "The event type, such as set, delete or expire."},
+			"key":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"value":     &graphql.Field{Type: graphql.String},
+			"encoding":  &graphql.Field{Type: graphql.String},
+			"oldValue":  &graphql.Field{Type: graphql.String},
+			"revision":  &graphql.Field{Type: graphql.NewNonNull(revisionScalar)},
+			"timestamp": &graphql.Field{Type: graphql.DateTime},
+			"origin":    &graphql.Field{Type: graphql.String},
+		},
+	})
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type: keyValue,
+				Args: graphql.FieldConfigArgument{"key": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key := p.Args["key"].(string)
+					if !validName(key) {
+						return nil, errors.New("invalid key")
+					}
+					e, ok := kv.GetEntry(nsKey(gqlNamespace(p.Context), key))
+					if !ok {
+						return nil, nil
+					}
+					return newGQLKeyValue(key, e), nil
+				},
+			},
+			"keys": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(keyValue))),
+				Args: graphql.FieldConfigArgument{
+					"prefix": {Type: graphql.String},
+					"first":  {Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					ns := gqlNamespace(p.Context)
+					prefix, _ := p.Args["prefix"].(string)
+					keys := kv.Keys(ns, func(key string) bool { return strings.HasPrefix(key, prefix) })
+					if first, ok := p.Args["first"].(int); ok && first >= 0 && first < len(keys) {
+						keys = keys[:first]
+					}
+					out := make([]*gqlKeyValue, 0, len(keys))
+					for _, key := range keys {
+						if e, ok := kv.GetEntry(nsKey(ns, key)); ok {
+							out = append(out, newGQLKeyValue(key, e))
+						}
+					}
+					return out, nil
+				},
+			},
+			"revision": &graphql.Field{
+				Type: graphql.NewNonNull(revisionScalar),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return kv.Seq(), nil
+				},
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"set": &graphql.Field{
+				Type: graphql.NewNonNull(keyValue),
+				Args: graphql.FieldConfigArgument{
+					"key":         {Type: graphql.NewNonNull(graphql.String)},
+					"value":       {Type: graphql.NewNonNull(graphql.String)},
+					"contentType": {Type: graphql.String},
+					"ttl":         {Type: graphql.String, Description: "A duration such as 30s, or seconds."},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key := p.Args["key"].(string)
+					if !validName(key) {
+						return nil, errors.New("invalid key")
+					}
+					ik := nsKey(gqlNamespace(p.Context), key)
+					if err := writeAccess(identity(p.Context), ik); err != nil {
+						return nil, err
+					}
+					ttlArg, _ := p.Args["ttl"].(string)
+					ttl, err := parseTTL(ttlArg)
+					if err != nil {
+						return nil, errors.New("invalid ttl")
+					}
+					e := newEntry(p.Args["value"].(string), ttl)
+					e.ContentType, _ = p.Args["contentType"].(string)
+					_, rev, err := kv.PutIf(p.Context, ik, e, nil)
+					if err != nil {
+						return nil, err
+					}
+					e.Rev = rev
+					return newGQLKeyValue(key, e), nil
+				},
+			},
+			"delete": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes a key and reports whether it existed.",
+				Args:        graphql.FieldConfigArgument{"key": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key := p.Args["key"].(string)
+					if !validName(key) {
+						return nil, errors.New("invalid key")
+					}
+					ik := nsKey(gqlNamespace(p.Context), key)
+					if err := writeAccess(identity(p.Context), ik); err != nil {
+						return nil, err
+					}
+					return kv.Delete(p.Context, ik), nil
+				},
+			},
+		},
+	})
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"keyChanged": &graphql.Field{
+				Type:        graphql.NewNonNull(keyChange),
+				Description: "Changes of a key, or of the keys under a prefix; without either, of every key.",
+				Args: graphql.FieldConfigArgument{
+					"key":    {Type: graphql.String},
+					"prefix": {Type: graphql.String},
+				},
+				Subscribe: func(p graphql.ResolveParams) (any, error) {
+					sub, _ := p.Context.Value(gqlSubKey{}).(*gqlSub)
+					if sub == nil {
+						return nil, errors.New("subscriptions need a websocket with the " + protocolGraphQL + " subprotocol")
+					}
+					key, _ := p.Args["key"].(string)
+					prefix, _ := p.Args["prefix"].(string)
+					if (key != "" && !validName(key)) || strings.Contains(prefix, nsSep) {
+						return nil, errors.New("invalid key")
+					}
+					sub.match = func(k string) bool {
+						if key != "" {
+							return k == key
+						}
+						return strings.HasPrefix(k, prefix)
+					}
+					sub.conn.mu.Lock()
+					sub.conn.subs[sub.id] = sub
+					sub.conn.mu.Unlock()
+					return sub.ch, nil
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation, Subscription: subscription})
+}
+
+// gqlRequest is a GraphQL request, from a POST body, the query string of
+// a GET or the payload of a "subscribe" message.
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// operation returns the type of the operation req runs: "query",
+// "mutation" or "subscription", or "" if the query does not parse, in
+// which case executing it reports why.
+func (req gqlRequest) operation() string {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return ""
+	}
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+				return op.Operation
+			}
+		}
+	}
+	return ""
+}
+
+// graphqlHandler serves GraphQL queries and mutations at /graphql, as GET
+// with ?query= or POST with a JSON body, and subscriptions over a
+// websocket upgrade with the graphql-transport-ws subprotocol. The
+// namespace is taken from ?ns=. Mutations are checked and rate limited
+// like the write routes, which /graphql is not one of.
+func (kv *KVStore) graphqlHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ns := q.Get("ns")
+		if strings.Contains(ns, nsSep) {
+			http.Error(w, "invalid namespace", 400)
+			return
+		}
+		if websocket.IsWebSocketUpgrade(r) {
+			kv.graphqlWS(w, r, schema, ns)
+			return
+		}
+		var req gqlRequest
+		switch r.Method {
+		case "GET":
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					http.Error(w, "invalid variables", 400)
+					return
+				}
+			}
+		case "POST":
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLRequest)).Decode(&req); err != nil {
+				http.Error(w, "invalid request", 400)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", 405)
+			return
+		}
+		switch req.operation() {
+		case "subscription":
+			http.Error(w, "subscriptions need a websocket with the "+protocolGraphQL+" subprotocol", 400)
+			return
+		case "mutation":
+			if r.Method != "POST" {
+				http.Error(w, "mutations need POST", 405)
+				return
+			}
+			if !limiter.allow(w, r) {
+				return
+			}
+		}
+		res := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(r.Context(), gqlNamespaceKey{}, ns),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// gqlMessage is a graphql-transport-ws message.
+type gqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// gqlReply is a graphql-transport-ws message sent to the client.
+type gqlReply struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+// gqlSub is an active keyChanged subscription. Matching changes are
+// handed to ch, which the GraphQL executor turns into results.
+type gqlSub struct {
+	id    string
+	conn  *gqlConn
+	match func(key string) bool
+	ch    chan any
+	ctx   context.Context
+}
+
+// gqlConn is the connection of a GraphQL websocket. It is registered as
+// a subscriber receiving versioned envelopes, which it hands to the
+// subscriptions they match instead of writing them out.
+type gqlConn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	// mu guards the subscriptions and the cancel functions of the
+	// running operations, by ID.
+	mu   sync.Mutex
+	subs map[string]*gqlSub
+	ops  map[string]context.CancelFunc
+}
+
+func (g *gqlConn) RemoteAddr() net.Addr { return g.ws.RemoteAddr() }
+
+func (g *gqlConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return g.ws.WriteControl(messageType, data, deadline)
+}
+
+func (g *gqlConn) EnableWriteCompression(bool) {}
+
+func (g *gqlConn) Close() error { return g.ws.Close() }
+
+// WriteMessage hands the change in data to the subscriptions it matches.
+func (g *gqlConn) WriteMessage(_ int, data []byte) error {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return err
+	}
+	changes := []envelope{env}
+	if env.Type == "batch" {
+		changes = env.Events
+	}
+	g.mu.Lock()
+	subs := make([]*gqlSub, 0, len(g.subs))
+	for _, s := range g.subs {
+		subs = append(subs, s)
+	}
+	g.mu.Unlock()
+	for _, change := range changes {
+		if change.Revision == 0 || change.Key == "" {
+			continue
+		}
+		for _, s := range subs {
+			if !s.match(change.Key) {
+				continue
+			}
+			select {
+			case s.ch <- newGQLKeyChange(change):
+			case <-s.ctx.Done():
+			}
+		}
+	}
+	return nil
+}
+
+// send writes a protocol message to the websocket.
+func (g *gqlConn) send(v gqlReply) error {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	return g.ws.WriteJSON(v)
+}
+
+// closeWith closes the websocket with a graphql-transport-ws close code.
+func (g *gqlConn) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	g.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	g.ws.Close()
+}
+
+// graphqlWS runs the graphql-transport-ws protocol on an upgraded
+// /graphql request. Queries and mutations may also be sent as
+// "subscribe" messages.
+func (kv *KVStore) graphqlWS(w http.ResponseWriter, r *http.Request, schema graphql.Schema, ns string) {
+	if !kv.admitConn() {
+		wsRejected.Add(1)
+		slog.WarnContext(r.Context(), "websocket connection limit reached", "remote", r.RemoteAddr)
+		http.Error(w, "too many websocket connections", 503)
+		return
+	}
+	defer kv.releaseConn()
+	u := upgrader
+	u.Subprotocols = []string{protocolGraphQL}
+	ws, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(r.Context(), "websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	g := &gqlConn{ws: ws, subs: make(map[string]*gqlSub), ops: make(map[string]context.CancelFunc)}
+	if ws.Subprotocol() != protocolGraphQL {
+		g.closeWith(4406, "Subprotocol not acceptable")
+		return
+	}
+	client := &wsClient{conn: g, ns: ns, id: identity(r.Context()), format: formatV2}
+	ctx := context.WithValue(r.Context(), gqlNamespaceKey{}, ns)
+	initialized := false
+	defer func() {
+		if initialized {
+			kv.removeConn(client)
+		}
+		g.mu.Lock()
+		for _, cancel := range g.ops {
+			cancel()
+		}
+		g.mu.Unlock()
+		ws.Close()
+	}()
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m gqlMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			g.closeWith(4400, "Invalid message")
+			return
+		}
+		switch m.Type {
+		case "connection_init":
+			if initialized {
+				g.closeWith(4429, "Too many initialisation requests")
+				return
+			}
+			initialized = true
+			kv.addConn(client)
+			g.send(gqlReply{Type: "connection_ack"})
+		case "ping":
+			g.send(gqlReply{Type: "pong"})
+		case "pong":
+		case "subscribe":
+			if !initialized {
+				g.closeWith(4401, "Unauthorized")
+				return
+			}
+			var req gqlRequest
+			if m.ID == "" || json.Unmarshal(m.Payload, &req) != nil {
+				g.closeWith(4400, "Invalid message")
+				return
+			}
+			g.mu.Lock()
+			if _, ok := g.ops[m.ID]; ok {
+				g.mu.Unlock()
+				g.closeWith(4409, "Subscriber for "+m.ID+" already exists")
+				return
+			}
+			octx, cancel := context.WithCancel(ctx)
+			g.ops[m.ID] = cancel
+			g.mu.Unlock()
+			go g.run(octx, cancel, schema, m.ID, req, r)
+		case "complete":
+			g.mu.Lock()
+			if cancel, ok := g.ops[m.ID]; ok {
+				cancel()
+				delete(g.ops, m.ID)
+			}
+			g.mu.Unlock()
+		default:
+			g.closeWith(4400, "Invalid message")
+			return
+		}
+	}
+}
+
+// run executes operation id and sends its results until it completes or
+// the client cancels it.
+func (g *gqlConn) run(ctx context.Context, cancel context.CancelFunc, schema graphql.Schema, id string, req gqlRequest, r *http.Request) {
+	defer cancel()
+	params := graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	}
+	var results chan *graphql.Result
+	switch req.operation() {
+	case "subscription":
+		sub := &gqlSub{id: id, conn: g, ch: make(chan any), ctx: ctx}
+		params.Context = context.WithValue(ctx, gqlSubKey{}, sub)
+		results = graphql.Subscribe(params)
+	case "mutation":
+		results = make(chan *graphql.Result, 1)
+		if limiter.reserve(limiter.client(r), time.Now()) > 0 {
+			results <- &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("rate limit exceeded"))}
+		} else {
+			results <- graphql.Do(params)
+		}
+		close(results)
+	default:
+		results = make(chan *graphql.Result, 1)
+		results <- graphql.Do(params)
+		close(results)
+	}
+	failed := false
+	for res := range results {
+		if ctx.Err() != nil {
+			continue
+		}
+		if res.Data == nil && res.HasErrors() {
+			failed = true
+			g.send(gqlReply{ID: id, Type: "error", Payload: res.Errors})
+			continue
+		}
+		g.send(gqlReply{ID: id, Type: "next", Payload: res})
+	}
+	g.mu.Lock()
+	delete(g.subs, id)
+	_, active := g.ops[id]
+	delete(g.ops, id)
+	g.mu.Unlock()
+	if active && !failed {
+		g.send(gqlReply{ID: id, Type: "complete"})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/matst80/go-info-share/infosharepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the KV service of infosharepb. It is mounted on the
+// API mux, so requests pass through the same authentication, role and
+// read-only checks as the HTTP routes; --grpc enables HTTP/2 without TLS
+// for it.
+type grpcServer struct {
+	pb.UnimplementedKVServer
+	kv *KVStore
+}
+
+func newGRPCServer(kv *KVStore) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterKVServer(s, &grpcServer{kv: kv})
+	return s
+}
+
+// grpcKey validates the namespace and key of a request and returns the
+// internal key.
+func grpcKey(ns, key string) (string, error) {
+	if strings.Contains(ns, nsSep) || !validName(key) {
+		return "", status.Error(codes.InvalidArgument, "invalid namespace or key")
+	}
+	return nsKey(ns, key), nil
+}
+
+// grpcError maps a store error to a gRPC status, like writeStoreError.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errValueTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, errPrecondition):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errKeyTooLong), errors.Is(err, errTooManyKeys), errors.Is(err, errInvalidValue), errors.Is(err, errWrongType):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (s *grpcServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	ik, err := grpcKey(req.Namespace, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := s.kv.GetEntry(ik)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &pb.GetResponse{Entry: protoEntry(req.Key, e)}, nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	ik, err := grpcKey(req.Namespace, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyAccess(identity(ctx), ik); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if ttl = req.Ttl.AsDuration(); ttl < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid ttl")
+		}
+	}
+	e := newEntry(string(req.Value), ttl)
+	e.ContentType = req.ContentType
+	_, rev, err := s.kv.PutIf(ctx, ik, e, nil)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.SetResponse{Revision: rev}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	ik, err := grpcKey(req.Namespace, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyAccess(identity(ctx), ik); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !s.kv.Delete(ctx, ik) {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *grpcServer) GetAll(ctx context.Context, req *pb.GetAllRequest) (*pb.GetAllResponse, error) {
+	if strings.Contains(req.Namespace, nsSep) {
+		return nil, status.Error(codes.InvalidArgument, "invalid namespace")
+	}
+	now := time.Now()
+	res := &pb.GetAllResponse{}
+	s.kv.mu.RLock()
+	res.Revision = s.kv.seq
+	for ik, e := range s.kv.data {
+		ns, key := splitKey(ik)
+		if ns != req.Namespace || !strings.HasPrefix(key, req.Prefix) || e.expired(now) {
+			continue
+		}
+		res.Entries = append(res.Entries, protoEntry(key, e))
+	}
+	s.kv.mu.RUnlock()
+	sort.Slice(res.Entries, func(i, j int) bool { return res.Entries[i].Key < res.Entries[j].Key })
+	return res, nil
+}
+
+// Watch subscribes the stream like a websocket client. Events are
+// encoded once per change and decoded again for each stream, which keeps
+// them on the shared send queues.
+func (s *grpcServer) Watch(req *pb.WatchRequest, stream grpc.ServerStreamingServer[pb.Event]) error {
+	ctx := stream.Context()
+	if strings.Contains(req.Namespace, nsSep) {
+		return status.Error(codes.InvalidArgument, "invalid namespace")
+	}
+	if !s.kv.admitConn() {
+		wsRejected.Add(1)
+		return status.Error(codes.ResourceExhausted, "too many connections")
+	}
+	defer s.kv.releaseConn()
+	var remote net.Addr = httpAddr("")
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr
+	}
+	conn := newStreamConn(remote, func(data []byte) error {
+		ev := new(pb.Event)
+		if err := proto.Unmarshal(data, ev); err != nil {
+			return err
+		}
+		return stream.Send(ev)
+	})
+	client := &wsClient{conn: conn, ns: req.Namespace, id: identity(ctx), format: formatProto}
+	for _, key := range req.Keys {
+		if err := s.kv.subscribeKeys(client, key, "", true); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	for _, prefix := range req.Prefixes {
+		if err := s.kv.subscribeKeys(client, "", prefix, true); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	switch {
+	case req.Since > 0:
+		s.kv.addConnResume(client, req.Since)
+	case req.Snapshot:
+		s.kv.addConnSnapshot(client)
+	default:
+		s.kv.addConn(client)
+	}
+	defer func() {
+		s.kv.removeConn(client)
+		conn.Close()
+	}()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-conn.done:
+		return status.Error(codes.Unavailable, "stream closed by the server")
+	}
+}
+
+func protoEntry(key string, e Entry) *pb.Entry {
+	out := &pb.Entry{Key: key, Value: []byte(e.Value), ContentType: e.ContentType, Revision: e.Rev, Type: e.Type}
+	if !e.ExpiresAt.IsZero() {
+		out.ExpiresAt = timestamppb.New(e.ExpiresAt)
+	}
+	return out
+}
+
+// protoMessage converts a message for a subscriber to its gRPC form.
+func protoMessage(v any) proto.Message {
+	switch v := v.(type) {
+	case Event:
+		return protoEvent(v)
+	case initialState:
+		return &pb.Event{Type: v.Type, Namespace: v.Namespace, Revision: v.Revision, Data: v.Data}
+	}
+	return &pb.Event{}
+}
+
+func protoEvent(ev Event) *pb.Event {
+	out := &pb.Event{
+		Type:        ev.Type,
+		Namespace:   ev.Namespace,
+		Key:         ev.Key,
+		ContentType: ev.ContentType,
+		ValueType:   ev.ValueType,
+		OldValue:    ev.Old,
+		Revision:    ev.Rev,
+		Origin:      ev.Origin,
+		Values:      ev.Values,
+		Fields:      ev.Fields,
+		Topic:       ev.Topic,
+	}
+	if ev.Value != "" || ev.Type == "set" {
+		value := []byte(ev.Value)
+		if ev.Encoding == "base64" {
+			value, _ = base64.StdEncoding.DecodeString(ev.Value)
+		}
+		out.Value = value
+	}
+	if !ev.Time.IsZero() {
+		out.Time = timestamppb.New(ev.Time)
+	}
+	for _, e := range ev.Events {
+		out.Events = append(out.Events, protoEvent(e))
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// hashOf decodes the fields of the hash stored in e. A missing key is an
+// empty hash.
+func hashOf(e Entry, exists bool) (map[string]string, error) {
+	fields := make(map[string]string)
+	if !exists {
+		return fields, nil
+	}
+	if e.Type != "hash" || json.Unmarshal([]byte(e.Value), &fields) != nil {
+		return nil, fmt.Errorf("%w: want hash", errWrongType)
+	}
+	return fields, nil
+}
+
+// withHash returns e holding fields as a hash, keeping its expiry.
+func withHash(e Entry, fields map[string]string) Entry {
+	data, _ := json.Marshal(fields)
+	e.Value, e.Type, e.ContentType = string(data), "hash", ""
+	return e
+}
+
+// HashSet sets fields of the hash at key, creating it if needed, and
+// returns the fields whose value changed. Subscribers get an "hset" event
+// with those fields.
+func (k *KVStore) HashSet(ctx context.Context, key string, fields map[string]string) (map[string]string, error) {
+	changed := make(map[string]string)
+	c, err := k.mutate(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		h, err := hashOf(cur, exists)
+		if err != nil {
+			return cur, false, err
+		}
+		clear(changed)
+		for f, v := range fields {
+			if old, ok := h[f]; !ok || old != v {
+				h[f] = v
+				changed[f] = v
+			}
+		}
+		if len(changed) == 0 {
+			return cur, false, errNoChange
+		}
+		return withHash(cur, h), false, nil
+	})
+	if errors.Is(err, errNoChange) {
+		return changed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.sendMu.Unlock()
+	k.broadcast(c.stamp(Event{Type: "hset", Key: key, Fields: changed}))
+	return changed, nil
+}
+
+// HashDelete removes fields from the hash at key and returns the names of
+// the removed fields. The key is deleted once the hash is empty.
+// Subscribers get an "hdel" event with the removed field names.
+func (k *KVStore) HashDelete(ctx context.Context, key string, fields []string) ([]string, error) {
+	removed := []string{}
+	c, err := k.mutate(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		h, err := hashOf(cur, exists)
+		if err != nil {
+			return cur, false, err
+		}
+		removed = removed[:0]
+		for _, f := range fields {
+			if _, ok := h[f]; ok {
+				delete(h, f)
+				removed = append(removed, f)
+			}
+		}
+		if len(removed) == 0 {
+			return cur, false, errNoChange
+		}
+		return withHash(cur, h), len(h) == 0, nil
+	})
+	if errors.Is(err, errNoChange) {
+		return removed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.sendMu.Unlock()
+	k.broadcast(c.stamp(Event{Type: "hdel", Key: key, Values: removed}))
+	return removed, nil
+}
+
+// Hash returns the fields of the hash at key.
+func (k *KVStore) Hash(key string) (map[string]string, error) {
+	e, ok := k.GetEntry(key)
+	return hashOf(e, ok)
+}
+
+// hashesHandler serves the hash operations:
+//
+//	/hset?key=&field=&value=...   set fields, paired by position, returns the changed ones
+//	/hdel?key=&field=...          delete fields, returns the removed ones
+//	/hget?key=&field=             the value of one field, 404 if it is missing
+//	/hgetall?key=                 all fields as a JSON object
+//	/hlen?key=                    number of fields
+func (kv *KVStore) hashesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	op := r.URL.Path[1:]
+	fields := q["field"]
+	var res any
+	var err error
+	switch op {
+	case "hset", "hdel":
+		if len(fields) == 0 {
+			http.Error(w, "missing field", 400)
+			return
+		}
+		if op == "hset" && len(q["value"]) != len(fields) {
+			http.Error(w, "need one value per field", 400)
+			return
+		}
+		if !allowKeys(w, r, key) {
+			return
+		}
+		if op == "hdel" {
+			res, err = kv.HashDelete(r.Context(), key, fields)
+			break
+		}
+		set := make(map[string]string, len(fields))
+		for i, f := range fields {
+			set[f] = q["value"][i]
+		}
+		res, err = kv.HashSet(r.Context(), key, set)
+	case "hget", "hgetall", "hlen":
+		var h map[string]string
+		if h, err = kv.Hash(key); err != nil {
+			break
+		}
+		switch op {
+		case "hget":
+			v, ok := h[q.Get("field")]
+			if !ok {
+				http.Error(w, "field not found", 404)
+				return
+			}
+			fmt.Fprint(w, v)
+			return
+		case "hgetall":
+			res = h
+		case "hlen":
+			res = len(h)
+		}
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
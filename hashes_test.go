@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	k.HashSet(ctx, "h", map[string]string{"a": "1", "b": "2"})
+	seq := k.Seq()
+	changed, err := k.HashSet(ctx, "h", map[string]string{"a": "1", "b": "3"})
+	if err != nil || !maps.Equal(changed, map[string]string{"b": "3"}) {
+		t.Errorf("HashSet = %v, %v; want only b changed", changed, err)
+	}
+	if k.Seq() != seq+1 {
+		t.Errorf("seq = %d, want %d", k.Seq(), seq+1)
+	}
+	if h, _ := k.Hash("h"); !maps.Equal(h, map[string]string{"a": "1", "b": "3"}) {
+		t.Errorf("hash = %v, want a=1 b=3", h)
+	}
+	if removed, _ := k.HashDelete(ctx, "h", []string{"a", "x"}); len(removed) != 1 || removed[0] != "a" {
+		t.Errorf("HashDelete = %v, want a removed", removed)
+	}
+	k.HashDelete(ctx, "h", []string{"b"})
+	if _, ok := k.Get("h"); ok {
+		t.Error("empty hash was kept")
+	}
+	k.Set(ctx, "s", "x")
+	if _, err := k.HashSet(ctx, "s", map[string]string{"a": "1"}); !errors.Is(err, errWrongType) {
+		t.Errorf("HashSet on a string: error %v, want errWrongType", err)
+	}
+}
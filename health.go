@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Pinger is implemented by storage backends that can check whether they
+// are reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Stats is a summary of the store's state for health probes.
+type Stats struct {
+	Keys        int    `json:"keys"`
+	Bytes       int64  `json:"bytes"`
+	Revision    uint64 `json:"revision"`
+	Connections int    `json:"connections"`
+	Uptime      string `json:"uptime"`
+}
+
+// started is when the process started, for Stats.Uptime.
+var started = time.Now()
+
+// Stats returns a summary of the store's state.
+func (k *KVStore) Stats() Stats {
+	k.mu.RLock()
+	s := Stats{Keys: len(k.data), Bytes: k.bytes, Revision: k.seq}
+	k.mu.RUnlock()
+	k.connMu.Lock()
+	s.Connections = len(k.conns)
+	k.connMu.Unlock()
+	s.Uptime = time.Since(started).Round(time.Second).String()
+	return s
+}
+
+// SetReady marks the store as ready to serve traffic, once it has been
+// hydrated from persistence and its listeners are up.
+func (k *KVStore) SetReady(ready bool) {
+	k.ready.Store(ready)
+}
+
+// healthzHandler reports that the process is alive.
+func (kv *KVStore) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+// readyzHandler reports 200 with the store stats once the store is ready
+// and its storage backend is reachable, and 503 otherwise.
+func (kv *KVStore) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Ready   bool   `json:"ready"`
+		Storage string `json:"storage"`
+		Stats
+	}{Ready: kv.ready.Load(), Storage: "ok", Stats: kv.Stats()}
+	if p, ok := kv.storage.(Pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			status.Ready, status.Storage = false, err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(503)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// removedHistories is the number of removed keys whose history is kept
+// for /history and rollback; older ones are dropped.
+const removedHistories = 1000
+
+// Version is one past state of a key.
+type Version struct {
+	Version     uint64    `json:"version"`
+	Value       string    `json:"value,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Deleted     bool      `json:"deleted,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+func versionSize(key string, v Version) int64 {
+	return int64(len(key)+len(v.Value)+len(v.ContentType)) + entryOverhead
+}
+
+// record appends c to the history of its key, dropping the oldest
+// versions beyond the configured depth. The history of evicted keys is
+// dropped at once, that of other removed keys once removedHistories
+// newer removals are kept. History counts towards the memory usage.
+// Callers must hold k.mu.
+func (k *KVStore) record(c Change) {
+	if k.historyDepth <= 0 {
+		return
+	}
+	if c.Op == "evict" {
+		k.dropHistory(c.Key)
+		return
+	}
+	v := Version{Version: k.seq, Value: c.Entry.Value, ContentType: c.Entry.ContentType, Type: c.Entry.Type, Deleted: c.Op != "set", Time: c.Time}
+	h := append(k.history[c.Key], v)
+	k.bytes += versionSize(c.Key, v)
+	if len(h) > k.historyDepth {
+		for _, old := range h[:len(h)-k.historyDepth] {
+			k.bytes -= versionSize(c.Key, old)
+		}
+		h = h[len(h)-k.historyDepth:]
+	}
+	k.history[c.Key] = h
+	if !v.Deleted {
+		return
+	}
+	k.removed = append(k.removed, c.Key)
+	if len(k.removed) > removedHistories {
+		key := k.removed[0]
+		k.removed = k.removed[1:]
+		// The key may have been set again since.
+		if h := k.history[key]; len(h) > 0 && h[len(h)-1].Deleted {
+			k.dropHistory(key)
+		}
+	}
+}
+
+// dropHistory forgets the history of key. Callers must hold k.mu.
+func (k *KVStore) dropHistory(key string) {
+	for _, v := range k.history[key] {
+		k.bytes -= versionSize(key, v)
+	}
+	delete(k.history, key)
+}
+
+// History returns the retained versions of key, oldest first. History
+// is kept in memory only and starts empty after a restart.
+func (k *KVStore) History(key string) []Version {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]Version(nil), k.history[key]...)
+}
+
+// Rollback sets key back to the value, content type and type it had at
+// version. It reports false if that version is not retained or was a
+// deletion.
+func (k *KVStore) Rollback(ctx context.Context, key string, version uint64) (bool, error) {
+	for _, v := range k.History(key) {
+		if v.Version == version && !v.Deleted {
+			_, err := k.Put(ctx, key, Entry{Value: v.Value, ContentType: v.ContentType, Type: v.Type})
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func (kv *KVStore) historyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kv.History(key))
+}
+
+func (kv *KVStore) rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	version, err := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
+	if key == "" || err != nil {
+		http.Error(w, "missing key or version", 400)
+		return
+	}
+	if !validName(key) {
+		http.Error(w, "invalid key", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	found, err := kv.Rollback(r.Context(), key, version)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
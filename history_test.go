@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	k, err := NewKVStore(nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, v := range []string{"1", "2", "3"} {
+		k.Set(ctx, "a", v)
+	}
+	k.Delete(ctx, "a")
+	h := k.History("a")
+	if len(h) != 2 || h[0].Value != "3" || h[0].Version != 3 || !h[1].Deleted {
+		t.Fatalf("history = %+v, want version 3 and the deletion", h)
+	}
+	if ok, _ := k.Rollback(ctx, "a", 1); ok {
+		t.Error("rollback to a version beyond the depth succeeded")
+	}
+	if ok, _ := k.Rollback(ctx, "a", h[1].Version); ok {
+		t.Error("rollback to a deletion succeeded")
+	}
+	if ok, err := k.Rollback(ctx, "a", 3); !ok || err != nil {
+		t.Fatalf("Rollback = %v, %v; want true", ok, err)
+	}
+	if v, _ := k.Get("a"); v != "3" {
+		t.Errorf("a = %q after the rollback, want 3", v)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theory/jsonpath"
+)
+
+// index maps the values a JSONPath expression selects from JSON entries
+// to the keys holding them.
+type index struct {
+	expr    string
+	path    *jsonpath.Path
+	byValue map[string]map[string]struct{}
+	byKey   map[string][]string
+}
+
+// indexValues returns the index terms p selects from value: strings
+// verbatim, other nodes as JSON. Values that are not JSON yield none.
+func indexValues(p *jsonpath.Path, value string) []string {
+	doc, err := decodeJSON(value)
+	if err != nil {
+		return nil
+	}
+	var terms []string
+	for _, n := range p.Select(doc) {
+		if s, ok := n.(string); ok {
+			terms = append(terms, s)
+			continue
+		}
+		b, _ := json.Marshal(n)
+		terms = append(terms, string(b))
+	}
+	return terms
+}
+
+func (ix *index) remove(key string) {
+	for _, v := range ix.byKey[key] {
+		delete(ix.byValue[v], key)
+		if len(ix.byValue[v]) == 0 {
+			delete(ix.byValue, v)
+		}
+	}
+	delete(ix.byKey, key)
+}
+
+func (ix *index) add(key string, e Entry) {
+	terms := indexValues(ix.path, e.Value)
+	if len(terms) == 0 {
+		return
+	}
+	ix.byKey[key] = terms
+	for _, v := range terms {
+		if ix.byValue[v] == nil {
+			ix.byValue[v] = make(map[string]struct{})
+		}
+		ix.byValue[v][key] = struct{}{}
+	}
+}
+
+// updateIndexes keeps the secondary and full-text indexes in sync with c. Callers
+// must hold k.mu.
+func (k *KVStore) updateIndexes(c Change) {
+	for _, ix := range k.indexes {
+		ix.remove(c.Key)
+		if c.Op == "set" {
+			ix.add(c.Key, c.Entry)
+		}
+	}
+	if k.search != nil {
+		k.search.remove(c.Key)
+		if c.Op == "set" {
+			k.search.add(c.Key, c.Entry)
+		}
+	}
+}
+
+// reindex rebuilds every index, including the full-text index, from
+// k.data. Callers must hold k.mu.
+func (k *KVStore) reindex() {
+	if k.search != nil {
+		k.search = newSearchIndex()
+		for key, e := range k.data {
+			k.search.add(key, e)
+		}
+	}
+	for _, ix := range k.indexes {
+		ix.byValue = make(map[string]map[string]struct{})
+		ix.byKey = make(map[string][]string)
+		for key, e := range k.data {
+			ix.add(key, e)
+		}
+	}
+}
+
+// AddIndex declares an index called name over the values the JSONPath
+// expression expr selects, replacing any index of the same name, and
+// builds it from the current data.
+func (k *KVStore) AddIndex(name, expr string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid index name %q", name)
+	}
+	p, err := jsonpath.Parse(expr)
+	if err != nil {
+		return err
+	}
+	ix := &index{expr: expr, path: p, byValue: make(map[string]map[string]struct{}), byKey: make(map[string][]string)}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, e := range k.data {
+		ix.add(key, e)
+	}
+	if k.indexes == nil {
+		k.indexes = make(map[string]*index)
+	}
+	k.indexes[name] = ix
+	return nil
+}
+
+// RemoveIndex drops the index called name and reports whether it
+// existed.
+func (k *KVStore) RemoveIndex(name string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.indexes[name]
+	delete(k.indexes, name)
+	return ok
+}
+
+// Indexes returns the JSONPath expression of every index by name.
+func (k *KVStore) Indexes() map[string]string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	all := make(map[string]string, len(k.indexes))
+	for name, ix := range k.indexes {
+		all[name] = ix.expr
+	}
+	return all
+}
+
+// Find returns the sorted live keys in namespace ns whose indexed value
+// equals value. ok is false if there is no such index.
+func (k *KVStore) Find(ns, name, value string) (keys []string, ok bool) {
+	now := time.Now()
+	keys = make([]string, 0)
+	k.mu.RLock()
+	ix, ok := k.indexes[name]
+	if ok {
+		for ik := range ix.byValue[value] {
+			if k.data[ik].expired(now) {
+				continue
+			}
+			if n, key := splitKey(ik); n == ns {
+				keys = append(keys, key)
+			}
+		}
+	}
+	k.mu.RUnlock()
+	sort.Strings(keys)
+	return keys, ok
+}
+
+// parseIndexFlag parses an --index value of the form name=$.path.
+func (k *KVStore) parseIndexFlag(s string) error {
+	name, expr, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("want name=jsonpath, got %q", s)
+	}
+	return k.AddIndex(name, expr)
+}
+
+func (kv *KVStore) findHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("index") == "" || !q.Has("value") {
+		http.Error(w, "missing index or value", 400)
+		return
+	}
+	keys, ok := kv.Find("", q.Get("index"), q.Get("value"))
+	if !ok {
+		http.Error(w, "unknown index", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// indexesHandler lists indexes on GET, declares ?name= over ?path= on
+// PUT or POST and drops it on DELETE.
+func (kv *KVStore) indexesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kv.Indexes())
+		return
+	case "PUT", "POST":
+		if err := kv.AddIndex(q.Get("name"), q.Get("path")); err != nil {
+			http.Error(w, "invalid index: "+err.Error(), 400)
+			return
+		}
+	case "DELETE":
+		if !kv.RemoveIndex(q.Get("name")) {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
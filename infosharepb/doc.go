@@ -0,0 +1,4 @@
+// Package infosharepb holds the generated gRPC API of go-info-share.
+package infosharepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative infoshare.proto
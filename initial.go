@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// initialState is the "snapshot" message sent right after the hello to
+// websocket clients that connect with ?snapshot=true. Data holds the live
+// keys of the namespace the client may see and subscribed to, with
+// binary values base64 encoded; Revision is the store revision it
+// reflects. Every later change arrives as an event.
+type initialState struct {
+	Type      string            `json:"type"`
+	Namespace string            `json:"namespace,omitempty"`
+	Revision  uint64            `json:"revision"`
+	Data      map[string]string `json:"data"`
+}
+
+// addConnSnapshot is addConn for clients that asked for a snapshot. The
+// snapshot is taken while writes are blocked and queued before the
+// client is added, so no change is missed or sent ahead of it.
+func (k *KVStore) addConnSnapshot(c *wsClient) {
+	now := time.Now()
+	k.mu.RLock()
+	state := initialState{Type: "snapshot", Namespace: c.ns, Revision: k.seq, Data: make(map[string]string)}
+	for ik, e := range k.data {
+		ns, key := splitKey(ik)
+		if ns != c.ns || e.expired(now) || !c.id.mayAccess(ns, key) || (c.subs != nil && !c.subs.match(key)) {
+			continue
+		}
+		state.Data[key], _ = e.jsonValue()
+	}
+	data := c.marshal(state)
+	if c.format == formatSSE {
+		// Let a reconnecting stream resume after the snapshot.
+		raw, _ := json.Marshal(state)
+		data = sseFrame(state.Revision, raw)
+	}
+	k.connMu.Lock()
+	k.mu.RUnlock()
+	k.registerConn(c, [][]byte{data})
+	k.connMu.Unlock()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// protocolJSONRPC is the websocket subprotocol for JSON-RPC 2.0 framing:
+// the ops of handleMessage are methods taking the rest of the message
+// as named params, and changes arrive as "event" notifications carrying
+// versioned envelopes.
+const protocolJSONRPC = "info-share.jsonrpc"
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcMethods are the methods a JSON-RPC client may call.
+var rpcMethods = map[string]bool{
+	"set": true, "delete": true, "get": true, "ephemeral": true,
+	"incr": true, "decr": true, "enqueue": true, "dequeue": true, "ack": true,
+	"subscribe": true, "unsubscribe": true,
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  *rpcResult      `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcResult is a wsReply without the fields JSON-RPC frames itself.
+type rpcResult struct {
+	Key      string `json:"key,omitempty"`
+	Rev      uint64 `json:"rev,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Delivery string `json:"delivery,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// rpcFrame wraps a message for a JSON-RPC client: responses pass as they
+// are, envelopes become "event" notifications and snapshots "snapshot"
+// ones. The only other message is the hello.
+func rpcFrame(v any) any {
+	switch v := v.(type) {
+	case rpcResponse:
+		return v
+	case envelope:
+		return rpcNotification{JSONRPC: "2.0", Method: "event", Params: v}
+	case initialState:
+		return rpcNotification{JSONRPC: "2.0", Method: v.Type, Params: v}
+	}
+	return rpcNotification{JSONRPC: "2.0", Method: "hello", Params: v}
+}
+
+// rpcFail replies to a request with id, null if it could not be read,
+// with an error.
+func (k *KVStore) rpcFail(c *wsClient, id json.RawMessage, code int, msg string) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	k.reply(c, rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{code, msg}})
+}
+
+// handleRPC runs a JSON-RPC request from client c. Notifications, that
+// is requests without an id, are run without a response. Batches are
+// not supported.
+func (k *KVStore) handleRPC(ctx context.Context, c *wsClient, data []byte) {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		k.rpcFail(c, nil, rpcInvalidRequest, "batch requests are not supported")
+		return
+	}
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		k.rpcFail(c, nil, rpcParseError, "parse error")
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		k.rpcFail(c, req.ID, rpcInvalidRequest, "invalid request")
+		return
+	}
+	if !rpcMethods[req.Method] {
+		k.rpcFail(c, req.ID, rpcMethodNotFound, "method not found")
+		return
+	}
+	var m wsMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &m); err != nil {
+			k.rpcFail(c, req.ID, rpcInvalidParams, "params must be an object")
+			return
+		}
+	}
+	m.Op, m.ID = req.Method, ""
+	k.runMessage(ctx, c, m, func(res wsReply) {
+		if req.ID == nil {
+			return
+		}
+		if res.Type == "error" {
+			k.rpcFail(c, req.ID, rpcServerError, res.Error)
+			return
+		}
+		k.reply(c, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: &rpcResult{
+			Key: res.Key, Rev: res.Rev, Value: res.Value, Encoding: res.Encoding, Delivery: res.Delivery, Topic: res.Topic,
+		}})
+	})
+}
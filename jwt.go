@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefresh bounds how often the key set is fetched: at most once per
+// jwksMinRefresh for unknown key IDs and at least once per jwksMaxAge.
+const (
+	jwksMinRefresh = time.Minute
+	jwksMaxAge     = time.Hour
+)
+
+// jwtConfig describes how bearer JWTs are validated. Tokens are signed
+// either with the shared Secret (HS256/384/512) or with a key published
+// at JWKSURL (RS*, PS* and ES*).
+type jwtConfig struct {
+	Secret       string
+	JWKSURL      string
+	Issuer       string
+	Audience     string
+	SubjectClaim string
+	// RoleClaim names the claim holding the client's role or roles and
+	// PrefixClaim the one with the key prefixes it is limited to.
+	RoleClaim   string
+	PrefixClaim string
+}
+
+// jwtVerifier validates bearer tokens against a jwtConfig.
+type jwtVerifier struct {
+	cfg    jwtConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]any
+	fetched time.Time
+}
+
+// newJWTVerifier returns a verifier for cfg, or nil if neither a secret
+// nor a JWKS URL is configured.
+func newJWTVerifier(cfg jwtConfig) *jwtVerifier {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	return &jwtVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Verify checks the signature, expiry, issuer and audience of token and
+// returns the identity it carries.
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if v.cfg.Secret == "" {
+				return nil, errors.New("hmac tokens are not accepted")
+			}
+			return []byte(v.cfg.Secret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			if v.cfg.JWKSURL == "" {
+				return nil, errors.New("asymmetric tokens are not accepted")
+			}
+			kid, _ := t.Header["kid"].(string)
+			return v.key(ctx, kid)
+		}
+		return nil, fmt.Errorf("unsupported signing method %s", t.Method.Alg())
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims[v.cfg.SubjectClaim].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("token has no %s claim", v.cfg.SubjectClaim)
+	}
+	return &Identity{
+		Subject:  sub,
+		Method:   "jwt",
+		Role:     claimRole(claims, v.cfg.RoleClaim),
+		Prefixes: claimPrefixes(claims, v.cfg.PrefixClaim),
+		Claims:   claims,
+	}, nil
+}
+
+// key returns the JWKS key with ID kid, fetching the key set when it is
+// stale or does not contain kid.
+func (v *jwtVerifier) key(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	k, ok := v.keys[kid]
+	age := time.Since(v.fetched)
+	if (!ok && age > jwksMinRefresh) || age > jwksMaxAge {
+		keys, err := v.fetch(ctx)
+		if err != nil {
+			if ok {
+				return k, nil
+			}
+			return nil, err
+		}
+		v.keys, v.fetched = keys, time.Now()
+		k, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return k, nil
+}
+
+// jwk is a JSON Web Key as published in a key set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch downloads the key set and decodes its RSA and EC signing keys.
+func (v *jwtVerifier) fetch(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// publicKey decodes k into an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k jwk) publicKey() (any, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := num(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := num(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
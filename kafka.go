@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// kafkaConfig configures the Kafka change feed. No Brokers disables it.
+type kafkaConfig struct {
+	Brokers []string
+	Topic   string
+	// Namespace is the namespace whose changes are produced.
+	Namespace string
+	// TLS connects to the brokers over TLS; Username and Password, if
+	// set, authenticate with SASL/PLAIN.
+	TLS      bool
+	Username string
+	Password string
+}
+
+// KafkaFeed produces every change of the store as a record keyed by the
+// KV key, with the versioned envelope as value. Records of a key go to
+// the same partition, so consumers see its changes in order.
+type KafkaFeed struct {
+	cfg    kafkaConfig
+	writer *kafka.Writer
+}
+
+// NewKafkaFeed starts producing changes to cfg.Topic. Records are sent
+// in the background; failed batches are logged.
+func NewKafkaFeed(kv *KVStore, cfg kafkaConfig) (*KafkaFeed, error) {
+	if cfg.Topic == "" {
+		return nil, errors.New("kafka topic must not be empty")
+	}
+	transport := &kafka.Transport{}
+	if cfg.TLS {
+		transport.TLS = &tls.Config{}
+	}
+	if cfg.Username != "" {
+		transport.SASL = plain.Mechanism{Username: cfg.Username, Password: cfg.Password}
+	}
+	f := &KafkaFeed{cfg: cfg}
+	f.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Async:        true,
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    transport,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				slog.Warn("kafka produce failed", "topic", cfg.Topic, "records", len(messages), "err", err)
+			}
+		},
+	}
+	kv.AddSink("kafka", f.produce)
+	return f, nil
+}
+
+// produce queues the record for ev.
+func (f *KafkaFeed) produce(ev Event) {
+	if ev.Namespace != f.cfg.Namespace {
+		return
+	}
+	data, err := json.Marshal(newEnvelope(ev))
+	if err != nil {
+		return
+	}
+	m := kafka.Message{Key: []byte(ev.Key), Value: data, Time: ev.Time}
+	// Writes of an async writer only fail once it is closed.
+	f.writer.WriteMessages(context.Background(), m)
+}
+
+// Close sends the records still buffered and closes the connections.
+func (f *KafkaFeed) Close() {
+	if err := f.writer.Close(); err != nil {
+		slog.Warn("kafka flush failed", "topic", f.cfg.Topic, "err", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Limits bounds what clients may store. Zero values disable a limit.
+type Limits struct {
+	MaxKeys       int
+	MaxKeyBytes   int
+	MaxValueBytes int64
+}
+
+// Limits returns the limits currently in force.
+func (k *KVStore) Limits() Limits {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.limits
+}
+
+// SetLimits replaces the limits. Existing data is not checked against
+// them.
+func (k *KVStore) SetLimits(l Limits) {
+	k.mu.Lock()
+	k.limits = l
+	k.mu.Unlock()
+}
+
+var (
+	errTooManyKeys = errors.New("too many keys")
+	errKeyTooLong  = errors.New("key too long")
+)
+
+// checkLimits reports whether storing e under key would exceed the
+// configured limits, its declared type or its schema. Callers must hold k.mu.
+func (k *KVStore) checkLimits(key string, e Entry) error {
+	l := k.limits
+	if _, name := splitKey(key); l.MaxKeyBytes > 0 && len(name) > l.MaxKeyBytes {
+		return errKeyTooLong
+	}
+	if l.MaxValueBytes > 0 && int64(len(e.Value)) > l.MaxValueBytes {
+		return errValueTooLarge
+	}
+	if l.MaxKeys > 0 {
+		if _, exists := k.data[key]; !exists && len(k.data) >= l.MaxKeys {
+			return errTooManyKeys
+		}
+	}
+	if err := checkType(e); err != nil {
+		return err
+	}
+	return k.schemas.Validate(key, e.Value)
+}
+
+// checkBulkLimits is checkLimits for a set of new values; the key count
+// limit accounts for all keys that would be created. Callers must hold
+// k.mu.
+func (k *KVStore) checkBulkLimits(values map[string]string) error {
+	added := 0
+	for key, value := range values {
+		if err := k.checkLimits(key, Entry{Value: value}); err != nil {
+			return err
+		}
+		if _, exists := k.data[key]; !exists {
+			added++
+		}
+	}
+	if k.limits.MaxKeys > 0 && len(k.data)+added > k.limits.MaxKeys {
+		return errTooManyKeys
+	}
+	return nil
+}
+
+// writeStoreError maps an error from a store write to an HTTP response.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errValueTooLarge):
+		http.Error(w, err.Error(), 413)
+	case errors.Is(err, errPrecondition):
+		http.Error(w, err.Error(), 412)
+	case errors.Is(err, errKeyTooLong), errors.Is(err, errTooManyKeys), errors.Is(err, errNotInteger), errors.Is(err, errInvalidValue), errors.Is(err, errWrongType):
+		http.Error(w, err.Error(), 422)
+	default:
+		slog.ErrorContext(r.Context(), "store write failed", "err", err)
+		http.Error(w, "internal error", 500)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keys returns the sorted live keys in namespace ns for which match
+// returns true. A nil match selects every key.
+func (k *KVStore) Keys(ns string, match func(key string) bool) []string {
+	now := time.Now()
+	keys := make([]string, 0)
+	k.mu.RLock()
+	for ik, e := range k.data {
+		if e.expired(now) {
+			continue
+		}
+		n, key := splitKey(ik)
+		if n == ns && (match == nil || match(key)) {
+			keys = append(keys, key)
+		}
+	}
+	k.mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// Count returns the number of live keys in namespace ns that start with
+// prefix.
+func (k *KVStore) Count(ns, prefix string) int {
+	now := time.Now()
+	n := 0
+	k.mu.RLock()
+	for ik, e := range k.data {
+		if e.expired(now) {
+			continue
+		}
+		if kns, key := splitKey(ik); kns == ns && strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	k.mu.RUnlock()
+	return n
+}
+
+// Page returns up to limit entries of namespace ns whose keys sort after
+// cursor and for which match returns true, with binary values base64
+// encoded. A nil match selects every entry. next is the cursor for the
+// following page, or empty when there are no more entries.
+func (k *KVStore) Page(ns, cursor string, limit int, match func(key string, e Entry) bool) (page map[string]string, next string) {
+	now := time.Now()
+	var keys []string
+	values := make(map[string]string)
+	k.mu.RLock()
+	for ik, e := range k.data {
+		if e.expired(now) {
+			continue
+		}
+		n, key := splitKey(ik)
+		if n == ns && key > cursor && (match == nil || match(key, e)) {
+			keys = append(keys, key)
+			values[key], _ = e.jsonValue()
+		}
+	}
+	k.mu.RUnlock()
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	page = make(map[string]string, len(keys))
+	for _, key := range keys {
+		page[key] = values[key]
+	}
+	return page, next
+}
+
+// encodeCursor and decodeCursor make page cursors opaque and URL safe.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	return string(b), err
+}
+
+// keyMatcher builds a key filter from a prefix and a glob pattern in
+// path.Match syntax, where "*" does not cross "/" boundaries. Empty
+// arguments match everything.
+func keyMatcher(prefix, glob string) (func(string) bool, error) {
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+	}
+	return func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if glob == "" {
+			return true
+		}
+		ok, _ := path.Match(glob, key)
+		return ok
+	}, nil
+}
+
+// entryMatcher builds a /getall filter from a key prefix, a regular
+// expression the key must match and a substring the value must contain.
+// Empty arguments match everything.
+func entryMatcher(prefix, keyRegex, valueContains string) (func(string, Entry) bool, error) {
+	var re *regexp.Regexp
+	if keyRegex != "" {
+		var err error
+		if re, err = regexp.Compile(keyRegex); err != nil {
+			return nil, err
+		}
+	}
+	return func(key string, e Entry) bool {
+		return strings.HasPrefix(key, prefix) &&
+			(re == nil || re.MatchString(key)) &&
+			strings.Contains(e.Value, valueContains)
+	}, nil
+}
+
+func (kv *KVStore) listHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	match, err := keyMatcher(q.Get("prefix"), q.Get("glob"))
+	if err != nil {
+		http.Error(w, "invalid glob", 400)
+		return
+	}
+	keys := kv.Keys("", match)
+	w.Header().Set("Content-Type", "application/json")
+	if q.Get("values") != "true" && q.Get("values") != "1" {
+		json.NewEncoder(w).Encode(keys)
+		return
+	}
+	values, _ := kv.GetBulk(keys)
+	json.NewEncoder(w).Encode(values)
+}
+
+func (kv *KVStore) keysHandler(w http.ResponseWriter, r *http.Request) {
+	match, _ := keyMatcher(r.URL.Query().Get("prefix"), "")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kv.Keys("", match))
+}
+
+func (kv *KVStore) countHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, kv.Count("", r.URL.Query().Get("prefix")))
+}
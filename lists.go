@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// listOf decodes the list stored in e. A missing key is an empty list.
+func listOf(e Entry, exists bool) ([]string, error) {
+	if !exists {
+		return nil, nil
+	}
+	if e.Type != "list" {
+		return nil, fmt.Errorf("%w: want list", errWrongType)
+	}
+	var items []string
+	if err := json.Unmarshal([]byte(e.Value), &items); err != nil {
+		return nil, fmt.Errorf("%w: want list", errWrongType)
+	}
+	return items, nil
+}
+
+// withList returns e holding items as a list, keeping its expiry.
+func withList(e Entry, items []string) Entry {
+	if items == nil {
+		items = []string{}
+	}
+	data, _ := json.Marshal(items)
+	e.Value, e.Type, e.ContentType = string(data), "list", ""
+	return e
+}
+
+// Push adds values to the head (front) or tail of the list at key,
+// creating it if needed, and returns the new length. Values pushed to the
+// head end up in reverse order, as with Redis LPUSH. Subscribers get an
+// "lpush" or "rpush" event with the pushed values.
+func (k *KVStore) Push(ctx context.Context, key string, values []string, front bool) (int, error) {
+	var n int
+	c, err := k.mutate(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		items, err := listOf(cur, exists)
+		if err != nil {
+			return cur, false, err
+		}
+		if front {
+			head := make([]string, 0, len(items)+len(values))
+			for i := len(values) - 1; i >= 0; i-- {
+				head = append(head, values[i])
+			}
+			items = append(head, items...)
+		} else {
+			items = append(items, values...)
+		}
+		n = len(items)
+		return withList(cur, items), false, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer k.sendMu.Unlock()
+	op := "rpush"
+	if front {
+		op = "lpush"
+	}
+	k.broadcast(c.stamp(Event{Type: op, Key: key, Values: values}))
+	return n, nil
+}
+
+// Pop removes up to count values from the head (front) or tail of the
+// list at key and returns them in removal order. The key is deleted once
+// the list is empty; popping an empty list changes nothing. Subscribers
+// get an "lpop" or "rpop" event with the removed values.
+func (k *KVStore) Pop(ctx context.Context, key string, count int, front bool) ([]string, error) {
+	var popped []string
+	c, err := k.mutate(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		items, err := listOf(cur, exists)
+		if err != nil {
+			return cur, false, err
+		}
+		count = min(count, len(items))
+		if count == 0 {
+			return cur, false, errNoChange
+		}
+		if front {
+			popped = append(popped, items[:count]...)
+			items = items[count:]
+		} else {
+			for i := len(items) - 1; i >= len(items)-count; i-- {
+				popped = append(popped, items[i])
+			}
+			items = items[:len(items)-count]
+		}
+		return withList(cur, items), len(items) == 0, nil
+	})
+	if errors.Is(err, errNoChange) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.sendMu.Unlock()
+	op := "rpop"
+	if front {
+		op = "lpop"
+	}
+	k.broadcast(c.stamp(Event{Type: op, Key: key, Values: popped}))
+	return popped, nil
+}
+
+// Range returns the items of the list at key from start to stop, both
+// inclusive. Negative indexes count from the end, so 0 and -1 select the
+// whole list. It also returns the length of the list.
+func (k *KVStore) Range(key string, start, stop int) ([]string, int, error) {
+	e, ok := k.GetEntry(key)
+	items, err := listOf(e, ok)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(items)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, n, nil
+	}
+	return items[start : stop+1], n, nil
+}
+
+// listsHandler serves the list operations:
+//
+//	/lpush, /rpush?key=&value=...   push one or more values, returns the length
+//	/lpop, /rpop?key=&count=        pop values, 404 if the list is empty
+//	/lrange?key=&start=&stop=       read a range of the list as JSON
+//	/llen?key=                      length of the list
+func (kv *KVStore) listsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	op := r.URL.Path[1:]
+	if op != "lrange" && op != "llen" && !allowKeys(w, r, key) {
+		return
+	}
+	var res any
+	var err error
+	switch op {
+	case "lpush", "rpush":
+		values := q["value"]
+		if len(values) == 0 {
+			http.Error(w, "missing value", 400)
+			return
+		}
+		res, err = kv.Push(r.Context(), key, values, op == "lpush")
+	case "lpop", "rpop":
+		count, ok := intParam(w, q.Get("count"), 1)
+		if !ok {
+			return
+		}
+		if count < 1 {
+			http.Error(w, "invalid count", 400)
+			return
+		}
+		var popped []string
+		popped, err = kv.Pop(r.Context(), key, count, op == "lpop")
+		if err == nil && len(popped) == 0 {
+			http.Error(w, "list is empty", 404)
+			return
+		}
+		res = popped
+	case "lrange":
+		start, ok := intParam(w, q.Get("start"), 0)
+		if !ok {
+			return
+		}
+		stop, ok := intParam(w, q.Get("stop"), -1)
+		if !ok {
+			return
+		}
+		res, _, err = kv.Range(key, start, stop)
+	case "llen":
+		_, res, err = kv.Range(key, 0, -1)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// intParam parses the integer query parameter s, defaulting to def when
+// it is empty. It writes a 400 response and returns false if s is not an
+// integer.
+func intParam(w http.ResponseWriter, s string, def int) (int, bool) {
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		http.Error(w, "invalid integer "+strconv.Quote(s), 400)
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestPushPop(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	if n, err := k.Push(ctx, "l", []string{"a", "b"}, false); err != nil || n != 2 {
+		t.Fatalf("rpush = %d, %v; want 2", n, err)
+	}
+	if n, err := k.Push(ctx, "l", []string{"x", "y"}, true); err != nil || n != 4 {
+		t.Fatalf("lpush = %d, %v; want 4", n, err)
+	}
+	items, n, err := k.Range("l", 0, -1)
+	if err != nil || n != 4 || !slices.Equal(items, []string{"y", "x", "a", "b"}) {
+		t.Fatalf("lrange = %q, %d, %v", items, n, err)
+	}
+	if items, _, _ := k.Range("l", -2, 10); !slices.Equal(items, []string{"a", "b"}) {
+		t.Errorf("lrange -2 10 = %q, want [a b]", items)
+	}
+	if popped, err := k.Pop(ctx, "l", 3, true); err != nil || !slices.Equal(popped, []string{"y", "x", "a"}) {
+		t.Errorf("lpop 3 = %q, %v", popped, err)
+	}
+	if popped, err := k.Pop(ctx, "l", 5, false); err != nil || !slices.Equal(popped, []string{"b"}) {
+		t.Errorf("rpop 5 = %q, %v", popped, err)
+	}
+	if _, ok := k.GetEntry("l"); ok {
+		t.Error("list still stored after popping its last item")
+	}
+}
+
+func TestPopEmpty(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	if popped, err := k.Pop(ctx, "missing", 1, true); err != nil || len(popped) != 0 {
+		t.Errorf("pop of a missing list = %q, %v", popped, err)
+	}
+	if _, err := k.Put(ctx, "empty", Entry{Value: "[]", Type: "list"}); err != nil {
+		t.Fatal(err)
+	}
+	seq := k.Seq()
+	if popped, err := k.Pop(ctx, "empty", 1, true); err != nil || len(popped) != 0 {
+		t.Errorf("pop of an empty list = %q, %v", popped, err)
+	}
+	if k.Seq() != seq {
+		t.Errorf("pop of an empty list committed a change")
+	}
+	if _, ok := k.GetEntry("empty"); !ok {
+		t.Error("pop of an empty list removed it")
+	}
+}
+
+func TestPopBroadcast(t *testing.T) {
+	k := newTestStore(t)
+	k.SetReplaySize(10)
+	ctx := context.Background()
+	k.Push(ctx, "l", []string{"a"}, false)
+	k.Pop(ctx, "l", 1, true)
+
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	evs, _ := k.replay.since(0)
+	if len(evs) != 2 || evs[1].Type != "lpop" || evs[1].Rev != k.seq {
+		t.Fatalf("events = %+v, want rpush then lpop at revision %d", evs, k.seq)
+	}
+}
+
+func TestListWrongType(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	k.Set(ctx, "s", "plain")
+	if _, err := k.Push(ctx, "s", []string{"a"}, false); err == nil {
+		t.Error("push onto a plain value succeeded")
+	}
+	if _, err := k.Pop(ctx, "s", 1, false); err == nil {
+		t.Error("pop from a plain value succeeded")
+	}
+}
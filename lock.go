@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultLeaseTTL is the lease duration of /lock/acquire without a ttl.
+const defaultLeaseTTL = 30 * time.Second
+
+// errNotHeld is returned when a lease is renewed or released with a
+// token that no longer holds it.
+var errNotHeld = errors.New("lock not held")
+
+// Lease is a lock held on a key until ExpiresAt. The fencing token is the
+// store revision of the acquiring write, so it grows with every new
+// holder and lets downstream systems reject writes from stale holders.
+type Lease struct {
+	Key       string    `json:"key"`
+	Owner     string    `json:"owner"`
+	Token     uint64    `json:"token"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// lease decodes the lease stored in e.
+func lease(e Entry) (Lease, bool) {
+	var l Lease
+	if e.Type != "json" || json.Unmarshal([]byte(e.Value), &l) != nil || l.Token == 0 {
+		return Lease{}, false
+	}
+	return l, true
+}
+
+// entry encodes l as a JSON value that expires with the lease.
+func (l Lease) entry() Entry {
+	data, _ := json.Marshal(l)
+	return Entry{Value: string(data), Type: "json", ExpiresAt: l.ExpiresAt}
+}
+
+// AcquireLock takes the lock on key for owner for ttl. If another lease
+// still holds the key it fails with errConflict and returns that lease.
+func (k *KVStore) AcquireLock(ctx context.Context, key, owner string, ttl time.Duration) (Lease, error) {
+	var l Lease
+	_, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if exists {
+			l, _ = lease(cur)
+			return cur, errConflict
+		}
+		// update holds k.mu, so the next revision is that of this write.
+		l = Lease{Key: key, Owner: owner, Token: k.seq + 1, TTL: ttl.String(), ExpiresAt: time.Now().Add(ttl)}
+		return l.entry(), nil
+	})
+	return l, err
+}
+
+// RenewLock extends the lease on key identified by token for ttl, or for
+// its original duration if ttl is zero.
+func (k *KVStore) RenewLock(ctx context.Context, key string, token uint64, ttl time.Duration) (Lease, error) {
+	var l Lease
+	_, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		var ok bool
+		if l, ok = lease(cur); !exists || !ok || l.Token != token {
+			return cur, errNotHeld
+		}
+		if ttl <= 0 {
+			ttl, _ = time.ParseDuration(l.TTL)
+		}
+		l.TTL, l.ExpiresAt = ttl.String(), time.Now().Add(ttl)
+		return l.entry(), nil
+	})
+	return l, err
+}
+
+// ReleaseLock deletes the lease on key identified by token.
+func (k *KVStore) ReleaseLock(ctx context.Context, key string, token uint64) error {
+	_, err := k.DeleteIf(ctx, key, func(cur Entry, exists bool) bool {
+		l, ok := lease(cur)
+		return exists && ok && l.Token == token
+	})
+	if errors.Is(err, errPrecondition) {
+		return errNotHeld
+	}
+	return err
+}
+
+// lockHandler serves /lock/acquire, /lock/renew and /lock/release. The
+// owner of a new lease defaults to the client's subject or address.
+func (kv *KVStore) lockHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	ttl, err := parseTTL(q.Get("ttl"))
+	if err != nil || ttl < 0 {
+		http.Error(w, "invalid ttl", 400)
+		return
+	}
+	var token uint64
+	if r.URL.Path != "/lock/acquire" {
+		if token, err = strconv.ParseUint(q.Get("token"), 10, 64); err != nil {
+			http.Error(w, "missing token", 400)
+			return
+		}
+	}
+	var l Lease
+	switch r.URL.Path {
+	case "/lock/acquire":
+		owner := q.Get("owner")
+		if owner == "" {
+			owner = subject(r.Context())
+		}
+		if owner == "" {
+			owner = r.RemoteAddr
+		}
+		if ttl == 0 {
+			ttl = defaultLeaseTTL
+		}
+		l, err = kv.AcquireLock(r.Context(), key, owner, ttl)
+		if errors.Is(err, errConflict) {
+			http.Error(w, fmt.Sprintf("lock held by %q", l.Owner), 409)
+			return
+		}
+	case "/lock/renew":
+		l, err = kv.RenewLock(r.Context(), key, token, ttl)
+	case "/lock/release":
+		if err = kv.ReleaseLock(r.Context(), key, token); err == nil {
+			fmt.Fprint(w, "ok")
+			return
+		}
+	}
+	if errors.Is(err, errNotHeld) {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
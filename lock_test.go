@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockLease(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	l, err := k.AcquireLock(ctx, "job", "a", time.Minute)
+	if err != nil || l.Owner != "a" || l.Token != k.Seq() {
+		t.Fatalf("AcquireLock = %+v, %v; want a lease for a fenced by the write's revision", l, err)
+	}
+	held, err := k.AcquireLock(ctx, "job", "b", time.Minute)
+	if !errors.Is(err, errConflict) || held.Owner != "a" {
+		t.Errorf("acquire of a held lock = %+v, %v; want errConflict and the holder's lease", held, err)
+	}
+	if _, err := k.RenewLock(ctx, "job", l.Token+1, 0); !errors.Is(err, errNotHeld) {
+		t.Errorf("renew with a wrong token: error %v, want errNotHeld", err)
+	}
+	r, err := k.RenewLock(ctx, "job", l.Token, 0)
+	if err != nil || r.Token != l.Token || r.TTL != "1m0s" || !r.ExpiresAt.After(l.ExpiresAt) {
+		t.Errorf("RenewLock = %+v, %v; want the same token extended by its ttl", r, err)
+	}
+	if err := k.ReleaseLock(ctx, "job", l.Token+1); !errors.Is(err, errNotHeld) {
+		t.Errorf("release with a wrong token: error %v, want errNotHeld", err)
+	}
+	if err := k.ReleaseLock(ctx, "job", l.Token); err != nil {
+		t.Fatal(err)
+	}
+	next, err := k.AcquireLock(ctx, "job", "b", time.Minute)
+	if err != nil || next.Token <= l.Token {
+		t.Errorf("acquire after release = %+v, %v; want a larger token", next, err)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	l, err := k.AcquireLock(ctx, "job", "a", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := k.RenewLock(ctx, "job", l.Token, 0); !errors.Is(err, errNotHeld) {
+		t.Errorf("renew of an expired lease: error %v, want errNotHeld", err)
+	}
+	if next, err := k.AcquireLock(ctx, "job", "b", time.Minute); err != nil || next.Owner != "b" {
+		t.Errorf("acquire of an expired lock = %+v, %v; want it taken by b", next, err)
+	}
+}
+
+func TestLockHandler(t *testing.T) {
+	k := newTestStore(t)
+	w := httptest.NewRecorder()
+	k.lockHandler(w, httptest.NewRequest("POST", "/lock/acquire?key=job&owner=a&ttl=1m", nil))
+	var l Lease
+	if w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &l) != nil || l.Owner != "a" {
+		t.Fatalf("acquire = %d %s, want the lease of a", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	k.lockHandler(w, httptest.NewRequest("POST", "/lock/acquire?key=job&owner=b", nil))
+	if w.Code != 409 {
+		t.Errorf("acquire of a held lock: status %d, want 409", w.Code)
+	}
+	w = httptest.NewRecorder()
+	k.lockHandler(w, httptest.NewRequest("POST", "/lock/renew?key=job", nil))
+	if w.Code != 400 {
+		t.Errorf("renew without a token: status %d, want 400", w.Code)
+	}
+	w = httptest.NewRecorder()
+	k.lockHandler(w, httptest.NewRequest("POST", "/lock/release?key=job&token=999", nil))
+	if w.Code != 409 {
+		t.Errorf("release with a wrong token: status %d, want 409", w.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the minimum level logged. It can be changed while running,
+// e.g. on a config reload.
+var logLevel slog.LevelVar
+
+// setupLogging installs the default slog logger writing text or JSON
+// records to stderr, tagged with request and trace IDs where known.
+// Output of the standard log package goes through it too.
+func setupLogging(format, level string) error {
+	if err := setLogLevel(level); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// setLogLevel parses level ("debug", "info", "warn" or "error") and
+// makes it the minimum logged level.
+func setLogLevel(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	return nil
+}
+
+// fatal logs msg and err at error level and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestKeyHandlersRejectInternalKeys(t *testing.T) {
+	k := newTestStore(t)
+	ik := nsKey("other", "secret")
+	k.Set(context.Background(), ik, "s")
+	target := "?key=" + url.QueryEscape(ik)
+	for _, tc := range []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{"get", "GET", k.getHandler},
+		{"delete", "DELETE", k.getHandler},
+		{"history", "GET", k.historyHandler},
+		{"query", "GET", k.queryHandler},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, "/"+tc.name+target+"&path=$", nil))
+		if w.Code != 400 {
+			t.Errorf("%s of an internal key: status %d, want 400", tc.name, w.Code)
+		}
+	}
+	if v, ok := k.Get(ik); !ok || v != "s" {
+		t.Errorf("internal key = %q, %v after the requests; want it unchanged", v, ok)
+	}
+}
+
+func TestGetDeleteHandlers(t *testing.T) {
+	k := newTestStore(t)
+	k.Set(context.Background(), "a", "1")
+	w := httptest.NewRecorder()
+	k.getHandler(w, httptest.NewRequest("GET", "/get?key=a", nil))
+	if w.Code != 200 || w.Body.String() != "1" {
+		t.Errorf("get a = %d %q, want 200 1", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	k.getHandler(w, httptest.NewRequest("DELETE", "/get?key=a", nil))
+	if w.Code != 200 {
+		t.Errorf("delete a: status %d, want 200", w.Code)
+	}
+	w = httptest.NewRecorder()
+	k.getHandler(w, httptest.NewRequest("GET", "/get?key=a", nil))
+	if w.Code != 404 {
+		t.Errorf("get of a deleted key: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedRelativeTTL is the largest exptime memcached treats as
+// seconds from now; larger values are Unix timestamps.
+const memcachedRelativeTTL = 30 * 24 * 60 * 60
+
+// memcachedStorage are the storage commands, which are followed by a
+// data block.
+var memcachedStorage = map[string]bool{
+	"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true,
+}
+
+// serveMemcachedConn runs the memcached text protocol commands of conn
+// against the default namespace: get, gets, set, add, replace, append,
+// prepend, cas, delete, incr, decr, touch, version and quit. Flags are
+// not stored and read back as 0, and the cas unique of a key is its
+// revision. Once any authentication method is configured, clients have
+// to authenticate as with memcached's text protocol authentication: the
+// first command is a set of any key whose data is "user password", or
+// an API key or bearer JWT.
+func (k *KVStore) serveMemcachedConn(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	c := new(wsClient)
+	authed := !authEnabled()
+	maxValue := k.Limits().MaxValueBytes
+	ctx := context.Background()
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				fmt.Fprint(w, "CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		name := args[0]
+		var data string
+		if memcachedStorage[name] {
+			if len(args) < 5 {
+				fmt.Fprint(w, "ERROR\r\n")
+				w.Flush()
+				continue
+			}
+			size, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || size < 0 || (maxValue > 0 && size > maxValue) {
+				fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+				w.Flush()
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			if string(buf[size:]) != "\r\n" {
+				fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+				w.Flush()
+				return
+			}
+			data = string(buf[:size])
+		}
+		var res string
+		switch {
+		case name == "quit":
+			w.Flush()
+			return
+		case !authed && name == "set":
+			user, password, ok := strings.Cut(data, " ")
+			if !ok {
+				user, password = "", data
+			}
+			id, err := connIdentity(ctx, user, password)
+			if err != nil {
+				slog.Info("authentication failed", "remote", conn.RemoteAddr().String(), "err", err)
+				res = "CLIENT_ERROR authentication failure"
+				break
+			}
+			c.id, authed = id, true
+			res = "STORED"
+		case !authed:
+			res = "CLIENT_ERROR unauthenticated"
+		default:
+			res = k.memcachedCommand(ctx, c, w, args, data)
+		}
+		if res != "" && !noreply(args) {
+			fmt.Fprintf(w, "%s\r\n", res)
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// noreply reports whether the command args asked for no reply.
+func noreply(args []string) bool {
+	return len(args) > 1 && args[len(args)-1] == "noreply" && args[0] != "get" && args[0] != "gets"
+}
+
+// memcachedCommand runs the command args, with the data block of storage
+// commands, for client c and returns the reply line. Retrievals write
+// their values to w themselves.
+func (k *KVStore) memcachedCommand(ctx context.Context, c *wsClient, w *bufio.Writer, args []string, data string) string {
+	name := args[0]
+	switch name {
+	case "version":
+		return "VERSION " + version
+	case "get", "gets":
+		if len(args) < 2 {
+			return "ERROR"
+		}
+		for _, key := range args[1:] {
+			if !validName(key) || !c.id.mayAccess("", key) {
+				continue
+			}
+			e, ok := k.GetEntry(key)
+			if !ok {
+				continue
+			}
+			if name == "gets" {
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, len(e.Value), e.Rev)
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(e.Value))
+			}
+			w.WriteString(e.Value)
+			w.WriteString("\r\n")
+		}
+		return "END"
+	case "delete":
+		if len(args) < 2 {
+			return "ERROR"
+		}
+		ik, err := c.mayWrite(args[1])
+		if err != nil {
+			return "CLIENT_ERROR " + err.Error()
+		}
+		if !k.Delete(ctx, ik) {
+			return "NOT_FOUND"
+		}
+		return "DELETED"
+	case "incr", "decr", "touch":
+		if len(args) < 3 {
+			return "ERROR"
+		}
+		return k.memcachedUpdate(ctx, c, name, args[1], args[2])
+	}
+	if !memcachedStorage[name] {
+		return "ERROR"
+	}
+	if name == "cas" && len(args) < 6 {
+		return "ERROR"
+	}
+	ik, err := c.mayWrite(args[1])
+	if err != nil {
+		return "CLIENT_ERROR " + err.Error()
+	}
+	exptime, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR bad command line format"
+	}
+	ttl, expired := memcachedTTL(exptime)
+	var unique uint64
+	if name == "cas" {
+		if unique, err = strconv.ParseUint(args[5], 10, 64); err != nil {
+			return "CLIENT_ERROR bad command line format"
+		}
+	}
+	_, err = k.update(ctx, ik, func(cur Entry, exists bool) (Entry, error) {
+		switch {
+		case name == "add" && exists,
+			(name == "replace" || name == "append" || name == "prepend") && !exists:
+			return cur, errConflict
+		case name == "cas" && !exists:
+			return cur, errNotFound
+		case name == "cas" && cur.Rev != unique:
+			return cur, errPrecondition
+		case name == "append":
+			cur.Value += data
+			return cur, nil
+		case name == "prepend":
+			cur.Value = data + cur.Value
+			return cur, nil
+		}
+		return newEntry(data, ttl), nil
+	})
+	switch {
+	case errors.Is(err, errConflict):
+		return "NOT_STORED"
+	case errors.Is(err, errNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, errPrecondition):
+		return "EXISTS"
+	case err != nil:
+		return "SERVER_ERROR " + err.Error()
+	}
+	if expired {
+		k.Delete(ctx, ik)
+	}
+	return "STORED"
+}
+
+// memcachedUpdate runs incr, decr or touch of key with arg, the amount
+// or the new exptime. Unlike Incr, missing keys are not created and
+// decr stops at zero, as in memcached.
+func (k *KVStore) memcachedUpdate(ctx context.Context, c *wsClient, name, key, arg string) string {
+	ik, err := c.mayWrite(key)
+	if err != nil {
+		return "CLIENT_ERROR " + err.Error()
+	}
+	var by uint64
+	var ttl time.Duration
+	var expired bool
+	if name == "touch" {
+		exptime, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR bad command line format"
+		}
+		ttl, expired = memcachedTTL(exptime)
+	} else if by, err = strconv.ParseUint(arg, 10, 64); err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument"
+	}
+	e, err := k.update(ctx, ik, func(cur Entry, exists bool) (Entry, error) {
+		if !exists {
+			return cur, errNotFound
+		}
+		if name == "touch" {
+			return newEntry(cur.Value, ttl), nil
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(cur.Value), 10, 64)
+		if err != nil {
+			return cur, errNotInteger
+		}
+		switch {
+		case name == "incr":
+			n += by
+		case by > n:
+			n = 0
+		default:
+			n -= by
+		}
+		cur.Value = strconv.FormatUint(n, 10)
+		return cur, nil
+	})
+	switch {
+	case errors.Is(err, errNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, errNotInteger):
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	case err != nil:
+		return "SERVER_ERROR " + err.Error()
+	}
+	if name != "touch" {
+		return e.Value
+	}
+	if expired {
+		k.Delete(ctx, ik)
+	}
+	return "TOUCHED"
+}
+
+// memcachedTTL converts a memcached exptime into a TTL: 0 never expires,
+// values up to 30 days are seconds from now and larger ones Unix
+// timestamps. expired is set for negative or past times.
+func memcachedTTL(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= memcachedRelativeTTL:
+		return time.Duration(exptime) * time.Second, false
+	}
+	ttl = time.Until(time.Unix(exptime, 0))
+	return ttl, ttl <= 0
+}
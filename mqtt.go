@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTimeout bounds how long a publish or subscribe waits for the
+// broker.
+const mqttTimeout = 10 * time.Second
+
+// mqttConfig configures the MQTT bridge. An empty Broker disables it.
+type mqttConfig struct {
+	// Broker is the broker URL: tcp://, ssl://, ws:// or wss://.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is put in front of a key to form the topic its
+	// changes are published to, e.g. info/ for info/{key}.
+	TopicPrefix string
+	// Namespace is the namespace whose keys are bridged.
+	Namespace string
+	QoS       int
+	// Retain publishes values as retained messages, so devices get the
+	// current value when they subscribe; removals clear them.
+	Retain bool
+	// Ingest is a topic filter such as info-in/# whose messages set the
+	// key below the filter's fixed prefix; empty payloads delete it.
+	Ingest string
+}
+
+// MQTTBridge publishes the changes of the store to an MQTT broker and
+// optionally writes the messages of a topic tree back to it.
+type MQTTBridge struct {
+	kv     *KVStore
+	cfg    mqttConfig
+	client mqtt.Client
+	// id is the identity ingested writes are made and authorized as.
+	id *Identity
+}
+
+// NewMQTTBridge connects to cfg.Broker, retrying in the background when
+// it cannot be reached, and starts publishing changes.
+func NewMQTTBridge(kv *KVStore, cfg mqttConfig) (*MQTTBridge, error) {
+	if cfg.QoS < 0 || cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", cfg.QoS)
+	}
+	if strings.ContainsAny(cfg.TopicPrefix, "+#") {
+		return nil, errors.New("mqtt topic prefix must not contain wildcards")
+	}
+	if cfg.ClientID == "" {
+		host, _ := os.Hostname()
+		cfg.ClientID = "info-share-" + host
+	}
+	b := &MQTTBridge{kv: kv, cfg: cfg, id: bridgeIdentity("mqtt")}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("mqtt connection lost", "broker", cfg.Broker, "err", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	b.client.Connect()
+	kv.AddSink("mqtt", b.publish)
+	return b, nil
+}
+
+// onConnect subscribes to the ingest filter, again after every
+// reconnect.
+func (b *MQTTBridge) onConnect(c mqtt.Client) {
+	slog.Info("mqtt connected", "broker", b.cfg.Broker)
+	if b.cfg.Ingest == "" {
+		return
+	}
+	mqttWait(c.Subscribe(b.cfg.Ingest, byte(b.cfg.QoS), b.ingest), "subscribe", b.cfg.Ingest)
+}
+
+// mqttWait waits for the broker to complete t, logging when it fails.
+func mqttWait(t mqtt.Token, op, topic string) {
+	err := errors.New("timed out")
+	if t.WaitTimeout(mqttTimeout) {
+		err = t.Error()
+	}
+	if err != nil {
+		slog.Warn("mqtt "+op+" failed", "topic", topic, "err", err)
+	}
+}
+
+// publish sends the value of the key of ev after the change to its
+// topic, or an empty message if the change removed it.
+func (b *MQTTBridge) publish(ev Event) {
+	if ev.Namespace != b.cfg.Namespace {
+		return
+	}
+	value, _ := b.kv.currentValue(ev)
+	topic := b.cfg.TopicPrefix + ev.Key
+	mqttWait(b.client.Publish(topic, byte(b.cfg.QoS), b.cfg.Retain, value), "publish", topic)
+}
+
+// ingest writes message to the key below the ingest filter's fixed
+// prefix. Values equal to the current one are skipped, so a filter that
+// overlaps the published topics does not loop.
+func (b *MQTTBridge) ingest(_ mqtt.Client, m mqtt.Message) {
+	prefix := b.cfg.Ingest
+	if i := strings.IndexAny(prefix, "+#"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	key := strings.TrimPrefix(m.Topic(), prefix)
+	if !validName(key) {
+		return
+	}
+	ik := nsKey(b.cfg.Namespace, key)
+	if err := writeAccess(b.id, ik); err != nil {
+		slog.Warn("mqtt ingest rejected", "topic", m.Topic(), "err", err)
+		return
+	}
+	ctx := context.WithValue(context.Background(), identityKey{}, b.id)
+	value := string(m.Payload())
+	if value == "" {
+		b.kv.Delete(ctx, ik)
+		return
+	}
+	_, err := b.kv.update(ctx, ik, func(cur Entry, exists bool) (Entry, error) {
+		if exists && cur.Value == value {
+			return cur, errNoChange
+		}
+		return newEntry(value, 0), nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		slog.Warn("mqtt ingest failed", "topic", m.Topic(), "err", err)
+	}
+}
+
+// Close disconnects from the broker, waiting briefly for pending
+// publishes.
+func (b *MQTTBridge) Close() {
+	b.client.Disconnect(250)
+}
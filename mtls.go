@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// clientCertAuth is set when clients authenticate with TLS certificates
+// signed by --tls-client-ca.
+var clientCertAuth bool
+
+// setupClientAuth makes srv ask clients for certificates signed by the
+// CAs in caFile. With mode "require" a handshake without a valid
+// certificate fails; with "optional" clients may connect without one and
+// are treated as anonymous.
+func setupClientAuth(srv *http.Server, caFile, mode string) error {
+	var auth tls.ClientAuthType
+	switch mode {
+	case "require":
+		auth = tls.RequireAndVerifyClientCert
+	case "optional":
+		auth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("unknown client auth mode %q", mode)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s: no certificates found", caFile)
+	}
+	if srv.TLSConfig == nil {
+		srv.TLSConfig = &tls.Config{}
+	}
+	srv.TLSConfig.ClientCAs = pool
+	srv.TLSConfig.ClientAuth = auth
+	clientCertAuth = true
+	return nil
+}
+
+// certIdentity returns the identity of the verified client certificate
+// of r, if any. The subject is the certificate's common name or, without
+// one, its first DNS, email or URI subject alternative name.
+func certIdentity(r *http.Request) *Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	var san []string
+	san = append(san, cert.DNSNames...)
+	san = append(san, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		san = append(san, u.String())
+	}
+	sub := cert.Subject.CommonName
+	if sub == "" && len(san) > 0 {
+		sub = san[0]
+	}
+	return &Identity{
+		Subject: sub,
+		Method:  "mtls",
+		Role:    defaultRole,
+		Claims:  map[string]any{"cn": cert.Subject.CommonName, "san": san, "serial": cert.SerialNumber.String()},
+	}
+}
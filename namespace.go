@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// nsSep separates the namespace from the key in the internal key space.
+// Keys in the default namespace are stored unprefixed.
+const nsSep = "\x00"
+
+// nsKey returns the internal key for key in namespace ns.
+func nsKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+	return ns + nsSep + key
+}
+
+// splitKey splits an internal key into its namespace and key.
+func splitKey(k string) (ns, key string) {
+	if i := strings.Index(k, nsSep); i >= 0 {
+		return k[:i], k[i+len(nsSep):]
+	}
+	return "", k
+}
+
+// validName reports whether s can be used as a key or namespace name.
+func validName(s string) bool {
+	return s != "" && !strings.Contains(s, nsSep)
+}
+
+// GetAllIn returns the live keys and values in namespace ns. Binary
+// values are base64 encoded.
+func (k *KVStore) GetAllIn(ns string) map[string]string {
+	now := time.Now()
+	k.mu.RLock()
+	out := make(map[string]string)
+	for ik, e := range k.data {
+		if e.expired(now) {
+			continue
+		}
+		if n, key := splitKey(ik); n == ns {
+			out[key], _ = e.jsonValue()
+		}
+	}
+	k.mu.RUnlock()
+	return out
+}
+
+func (kv *KVStore) nsGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	ns := r.PathValue("ns")
+	if !validName(ns) {
+		http.Error(w, "invalid namespace", 400)
+		return
+	}
+	if notModified(w, r, etag(kv.Seq())) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kv.GetAllIn(ns))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsOps are the websocket ops served on the request subject.
+var natsOps = map[string]bool{"set": true, "delete": true, "get": true, "incr": true, "decr": true}
+
+// natsConfig configures the NATS bridge. An empty URL disables it.
+type natsConfig struct {
+	// URL is the server URL, or a comma separated list of them.
+	URL string
+	// Creds is a credentials file with the user JWT and NKey seed.
+	Creds string
+	// Subject is the subject changes are published to. {key} is
+	// replaced with the key, its "/" turned into ".", so with info.{key}
+	// changes of app/x go to info.app.x and app/* can be followed with
+	// info.app.>.
+	Subject string
+	// Namespace is the namespace whose keys are bridged.
+	Namespace string
+	// RequestSubject receives websocket messages such as
+	// {"op":"set","key":"k","value":"v"} for set, delete, get, incr and
+	// decr, answered with the websocket reply. Empty disables it.
+	RequestSubject string
+	// QueueGroup shares the requests among the instances subscribed
+	// with the same group.
+	QueueGroup string
+}
+
+// NATSBridge publishes the changes of the store as versioned envelopes on
+// NATS subjects and serves requests to the store from a request subject.
+type NATSBridge struct {
+	kv   *KVStore
+	cfg  natsConfig
+	conn *nats.Conn
+	// c is the client requests run as.
+	c *wsClient
+}
+
+// NewNATSBridge connects to cfg.URL, retrying in the background when it
+// cannot be reached, and starts publishing changes.
+func NewNATSBridge(kv *KVStore, cfg natsConfig) (*NATSBridge, error) {
+	if !strings.Contains(cfg.Subject, "{key}") {
+		return nil, errors.New("nats subject must contain {key}")
+	}
+	opts := []nats.Option{
+		nats.Name("info-share"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ConnectHandler(func(*nats.Conn) { slog.Info("nats connected", "url", cfg.URL) }),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("nats connection lost", "url", cfg.URL, "err", err)
+		}),
+	}
+	if cfg.Creds != "" {
+		opts = append(opts, nats.UserCredentials(cfg.Creds))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	b := &NATSBridge{kv: kv, cfg: cfg, conn: conn, c: &wsClient{ns: cfg.Namespace, id: bridgeIdentity("nats")}}
+	if cfg.RequestSubject != "" {
+		if _, err := conn.QueueSubscribe(cfg.RequestSubject, cfg.QueueGroup, b.request); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	kv.AddSink("nats", b.publish)
+	return b, nil
+}
+
+// natsSubject returns the subject for key from the template subject.
+// Characters that cannot appear in subjects become "_" and empty tokens
+// are dropped.
+func natsSubject(subject, key string) string {
+	key = strings.Map(func(r rune) rune {
+		switch r {
+		case '/':
+			return '.'
+		case ' ', '\t', '\r', '\n', '*', '>':
+			return '_'
+		}
+		return r
+	}, key)
+	tokens := strings.FieldsFunc(key, func(r rune) bool { return r == '.' })
+	return strings.ReplaceAll(subject, "{key}", strings.Join(tokens, "."))
+}
+
+// publish sends the envelope of ev to the subject of its key.
+func (b *NATSBridge) publish(ev Event) {
+	if ev.Namespace != b.cfg.Namespace {
+		return
+	}
+	data, err := json.Marshal(newEnvelope(ev))
+	if err != nil {
+		return
+	}
+	subject := natsSubject(b.cfg.Subject, ev.Key)
+	if err := b.conn.Publish(subject, data); err != nil {
+		slog.Warn("nats publish failed", "subject", subject, "err", err)
+	}
+}
+
+// request runs the websocket message in m and responds with the reply if
+// m asked for one.
+func (b *NATSBridge) request(m *nats.Msg) {
+	var msg wsMessage
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		b.respond(m, wsReply{Type: "error", Error: "invalid json"})
+		return
+	}
+	if msg.Op == "" {
+		msg.Op = msg.Type
+	}
+	if !natsOps[msg.Op] {
+		b.respond(m, wsReply{Type: "error", ID: msg.ID, Op: msg.Op, Error: "unknown op"})
+		return
+	}
+	ctx := context.WithValue(context.Background(), identityKey{}, b.c.id)
+	b.kv.runMessage(ctx, b.c, msg, func(res wsReply) { b.respond(m, res) })
+}
+
+func (b *NATSBridge) respond(m *nats.Msg, res wsReply) {
+	if m.Reply == "" {
+		return
+	}
+	data, _ := json.Marshal(res)
+	if err := m.Respond(data); err != nil {
+		slog.Warn("nats respond failed", "subject", m.Subject, "err", err)
+	}
+}
+
+// Close waits briefly for pending publishes and disconnects.
+func (b *NATSBridge) Close() {
+	b.conn.FlushTimeout(time.Second)
+	b.conn.Close()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "info_share_session"
+	stateCookie   = "info_share_oidc_state"
+)
+
+// oidcConfig describes the OpenID Connect provider humans log in with.
+type oidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the public URL of /auth/callback.
+	RedirectURL  string
+	Scopes       string
+	SubjectClaim string
+	RoleClaim    string
+	PrefixClaim  string
+}
+
+// oidcProvider runs the authorization code flow against an OpenID
+// Connect provider. The ID token it returns is kept in a session cookie
+// and validated on every request, so the server holds no session state.
+type oidcProvider struct {
+	cfg          oidcConfig
+	authURL      string
+	tokenURL     string
+	verifier     *jwtVerifier
+	client       *http.Client
+	secureCookie bool
+}
+
+// oidcAuth is the provider used for browser logins; nil when OIDC is
+// off.
+var oidcAuth *oidcProvider
+
+// newOIDCProvider reads the provider's discovery document.
+func newOIDCProvider(ctx context.Context, cfg oidcConfig) (*oidcProvider, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("--oidc-issuer needs --oidc-client-id and --oidc-redirect-url")
+	}
+	p := &oidcProvider{
+		cfg:          cfg,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		secureCookie: strings.HasPrefix(cfg.RedirectURL, "https://"),
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURI  string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	p.authURL, p.tokenURL = doc.AuthURL, doc.TokenURL
+	p.verifier = newJWTVerifier(jwtConfig{
+		JWKSURL:      doc.JWKSURI,
+		Issuer:       doc.Issuer,
+		Audience:     cfg.ClientID,
+		SubjectClaim: cfg.SubjectClaim,
+		RoleClaim:    cfg.RoleClaim,
+		PrefixClaim:  cfg.PrefixClaim,
+	})
+	return p, nil
+}
+
+// session returns the identity of the logged in user of r, nil if there
+// is no session cookie, or an error if the session is not valid.
+func (p *oidcProvider) session(r *http.Request) (*Identity, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	id, err := p.verifier.Verify(r.Context(), c.Value)
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	id.Method = "oidc"
+	return id, nil
+}
+
+// randomToken returns a random URL safe string.
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// loginHandler redirects to the provider. The state and nonce, plus the
+// local path to return to, are kept in a short lived cookie.
+func (p *oidcProvider) loginHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	state, nonce := randomToken(), randomToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "." + nonce + "." + base64.RawURLEncoding.EncodeToString([]byte(next)),
+		Path:     "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   p.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"scope":         {p.cfg.Scopes},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	http.Redirect(w, r, p.authURL+"?"+q.Encode(), 302)
+}
+
+// callbackHandler exchanges the authorization code for an ID token and
+// stores it in the session cookie.
+func (p *oidcProvider) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookie)
+	parts := []string{}
+	if err == nil {
+		parts = strings.Split(c.Value, ".")
+	}
+	if len(parts) != 3 || r.URL.Query().Get("state") != parts[0] {
+		http.Error(w, "invalid login state", 400)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, 401)
+		return
+	}
+	token, err := p.exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "oidc code exchange failed", "err", err)
+		http.Error(w, "login failed", 502)
+		return
+	}
+	id, err := p.verifier.Verify(r.Context(), token)
+	if err != nil || id.Claims["nonce"] != parts[1] {
+		slog.WarnContext(r.Context(), "invalid oidc id token", "err", err)
+		http.Error(w, "login failed", 401)
+		return
+	}
+	exp, _ := id.Claims["exp"].(float64)
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(int64(exp), 0),
+		HttpOnly: true,
+		Secure:   p.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	slog.InfoContext(r.Context(), "oidc login", "subject", id.Subject)
+	next, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	http.Redirect(w, r, string(next), 302)
+}
+
+// exchange redeems code at the token endpoint and returns the ID token.
+func (p *oidcProvider) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// logoutHandler clears the session cookie.
+func (p *oidcProvider) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	fmt.Fprint(w, "ok")
+}
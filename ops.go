@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errConflict is returned when a conditional write does not apply.
+var errConflict = errors.New("conflict")
+
+// errNoChange aborts a mutation that would leave the entry unchanged.
+var errNoChange = errors.New("no change")
+
+// errNotInteger is returned when a numeric operation meets a value that
+// is not an integer.
+var errNotInteger = errors.New("value is not an integer")
+
+// update atomically replaces the entry for key with the result of fn.
+// fn receives the current entry and whether it exists; if it returns an
+// error the store is left unchanged. The new entry is broadcast like a
+// normal set, along with the keys written by its set hooks.
+func (k *KVStore) update(ctx context.Context, key string, fn func(cur Entry, exists bool) (Entry, error)) (Entry, error) {
+	cs, err := k.mutateHooked(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		e, err := fn(cur, exists)
+		return e, false, err
+	}, true)
+	if err != nil {
+		return cs[0].Entry, err
+	}
+	defer k.sendMu.Unlock()
+	k.broadcastChanges(cs)
+	return cs[0].Entry, nil
+}
+
+// mutate is update without the broadcast, for callers that describe the
+// change with an event of their own. fn may also ask for the key to be
+// deleted by returning remove. The committed change is returned; its Op
+// is empty if nothing changed. On error the change holds the current
+// entry. Unless it fails, mutate returns holding k.sendMu, see
+// unlockSending, which callers release once they broadcast the change.
+// Keys with set hooks cannot be stored through it.
+func (k *KVStore) mutate(ctx context.Context, key string, fn func(cur Entry, exists bool) (e Entry, remove bool, err error)) (Change, error) {
+	cs, err := k.mutateHooked(ctx, key, fn, false)
+	return cs[0], err
+}
+
+// mutateHooked is mutate running the set hooks of key if hooks is set,
+// and refusing keys with hooks otherwise. It returns the change followed
+// by those of the keys the hooks wrote.
+func (k *KVStore) mutateHooked(ctx context.Context, key string, fn func(cur Entry, exists bool) (e Entry, remove bool, err error), hooks bool) ([]Change, error) {
+	now := time.Now()
+	k.mu.Lock()
+	cur, ok := k.data[key]
+	if ok && cur.expired(now) {
+		cur, ok = Entry{}, false
+	}
+	e, remove, err := fn(cur, ok)
+	var run *scriptRun
+	if err == nil && !remove {
+		if hooks {
+			run, err = k.runSetHooks(ctx, key, &e)
+		} else {
+			err = k.refuseHooked(key)
+		}
+	}
+	if err == nil && !remove {
+		err = k.checkLimits(key, e)
+	}
+	if err != nil {
+		k.mu.Unlock()
+		return []Change{{Entry: cur}}, err
+	}
+	c := Change{Op: "set", Key: key, Entry: e, Time: now}
+	if remove {
+		if !ok {
+			k.unlockSending()
+			return []Change{{}}, nil
+		}
+		c = Change{Op: "delete", Key: key, Time: now}
+	}
+	k.commit(ctx, &c)
+	cs := append([]Change{c}, run.commit()...)
+	k.unlockSending()
+	return cs, nil
+}
+
+// CompareAndSwap sets key to value only if its current value equals
+// expected. It reports whether the swap happened.
+func (k *KVStore) CompareAndSwap(ctx context.Context, key, expected, value string, ttl time.Duration) (bool, error) {
+	_, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if !exists || cur.Value != expected {
+			return cur, errConflict
+		}
+		return newEntry(value, ttl), nil
+	})
+	return conditional(err)
+}
+
+// SetNX sets key to value only if the key does not exist. It reports
+// whether the write won.
+func (k *KVStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	_, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if exists {
+			return cur, errConflict
+		}
+		return newEntry(value, ttl), nil
+	})
+	return conditional(err)
+}
+
+// conditional turns the result of a conditional update into whether it
+// applied, keeping only errors other than errConflict.
+func conditional(err error) (bool, error) {
+	if errors.Is(err, errConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr atomically adds by to the integer stored at key and returns the
+// new value. A missing key counts as zero; an existing expiry is kept.
+func (k *KVStore) Incr(ctx context.Context, key string, by int64) (int64, error) {
+	var n int64
+	_, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if exists {
+			v, err := strconv.ParseInt(strings.TrimSpace(cur.Value), 10, 64)
+			if err != nil {
+				return cur, errNotInteger
+			}
+			n = v
+		}
+		n += by
+		cur.Value = strconv.FormatInt(n, 10)
+		return cur, nil
+	})
+	return n, err
+}
+
+// Append atomically appends value to the string stored at key, creating
+// the key if it is missing. sep is inserted between the existing value
+// and value when the key already holds a non-empty value. It returns the
+// new value.
+func (k *KVStore) Append(ctx context.Context, key, value, sep string) (string, error) {
+	e, err := k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if cur.Value != "" {
+			cur.Value += sep
+		}
+		cur.Value += value
+		return cur, nil
+	})
+	return e.Value, err
+}
+
+// newEntry returns an entry holding value that expires after ttl, or
+// never if ttl is zero or less.
+func newEntry(value string, ttl time.Duration) Entry {
+	e := Entry{Value: value}
+	if ttl > 0 {
+		e.ExpiresAt = time.Now().Add(ttl)
+	}
+	return e
+}
+
+func (kv *KVStore) casHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, value := q.Get("key"), q.Get("value")
+	if !validName(key) || value == "" || !q.Has("expected") {
+		http.Error(w, "missing key, expected or value", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	ttl, err := parseTTL(q.Get("ttl"))
+	if err != nil {
+		http.Error(w, "invalid ttl", 400)
+		return
+	}
+	swapped, err := kv.CompareAndSwap(r.Context(), key, q.Get("expected"), value, ttl)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if !swapped {
+		http.Error(w, "value does not match expected", 409)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
+
+func (kv *KVStore) setNXHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, value := q.Get("key"), q.Get("value")
+	if !validName(key) || value == "" {
+		http.Error(w, "missing key or value", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	ttl, err := parseTTL(q.Get("ttl"))
+	if err != nil {
+		http.Error(w, "invalid ttl", 400)
+		return
+	}
+	won, err := kv.SetNX(r.Context(), key, value, ttl)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if !won {
+		http.Error(w, "key already exists", 409)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
+
+func (kv *KVStore) incrHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	by := int64(1)
+	if s := q.Get("by"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid by", 400)
+			return
+		}
+		by = n
+	}
+	if r.URL.Path == "/decr" {
+		by = -by
+	}
+	n, err := kv.Incr(r.Context(), key, by)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	fmt.Fprint(w, n)
+}
+
+func (kv *KVStore) appendHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, value := q.Get("key"), q.Get("value")
+	if !validName(key) || value == "" {
+		http.Error(w, "missing key or value", 400)
+		return
+	}
+	if !allowKeys(w, r, key) {
+		return
+	}
+	if _, err := kv.Append(r.Context(), key, value, q.Get("sep")); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
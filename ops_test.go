@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIncr(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	if n, err := k.Incr(ctx, "n", 5); err != nil || n != 5 {
+		t.Errorf("incr of a missing key = %d, %v; want 5", n, err)
+	}
+	if n, err := k.Incr(ctx, "n", -7); err != nil || n != -2 {
+		t.Errorf("decr = %d, %v; want -2", n, err)
+	}
+	k.Put(ctx, "ttl", newEntry("1", time.Minute))
+	k.Incr(ctx, "ttl", 1)
+	if e, _ := k.GetEntry("ttl"); e.Value != "2" || e.ExpiresAt.IsZero() {
+		t.Errorf("ttl = %+v, want 2 with its expiry kept", e)
+	}
+	k.Set(ctx, "s", "x")
+	if _, err := k.Incr(ctx, "s", 1); !errors.Is(err, errNotInteger) {
+		t.Errorf("incr of a string: error %v, want errNotInteger", err)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	if ok, err := k.CompareAndSwap(ctx, "a", "", "1", 0); ok || err != nil {
+		t.Errorf("swap of a missing key = %v, %v; want false", ok, err)
+	}
+	k.Set(ctx, "a", "1")
+	seq := k.Seq()
+	if ok, _ := k.CompareAndSwap(ctx, "a", "2", "3", 0); ok || k.Seq() != seq {
+		t.Error("swap with a stale value was committed")
+	}
+	if ok, err := k.CompareAndSwap(ctx, "a", "1", "2", 0); !ok || err != nil {
+		t.Errorf("swap = %v, %v; want true", ok, err)
+	}
+	if v, _ := k.Get("a"); v != "2" {
+		t.Errorf("a = %q, want 2", v)
+	}
+}
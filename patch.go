@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+var (
+	// errNotFound is returned when an update targets a missing key.
+	errNotFound = errors.New("key not found")
+	// errNotJSON is returned when a patch targets a value that is not a
+	// JSON document.
+	errNotJSON = errors.New("value is not a JSON document")
+	// errPatchFailed is returned when a patch does not apply, e.g. because
+	// a "test" operation failed or a path does not exist.
+	errPatchFailed = errors.New("patch does not apply")
+)
+
+// JSONPatch atomically applies an RFC 6902 JSON Patch to the JSON
+// document stored at key and returns the patched entry. Content type,
+// value type and expiry are kept.
+func (k *KVStore) JSONPatch(ctx context.Context, key string, patch jsonpatch.Patch) (Entry, error) {
+	return k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if !exists {
+			return cur, errNotFound
+		}
+		if !json.Valid([]byte(cur.Value)) {
+			return cur, errNotJSON
+		}
+		doc, err := patch.Apply([]byte(cur.Value))
+		if err != nil {
+			return cur, fmt.Errorf("%w: %v", errPatchFailed, err)
+		}
+		cur.Value = string(doc)
+		return cur, nil
+	})
+}
+
+// MergePatch atomically applies an RFC 7386 JSON Merge Patch to the JSON
+// document stored at key and returns the patched entry. Content type,
+// value type and expiry are kept.
+func (k *KVStore) MergePatch(ctx context.Context, key string, patch []byte) (Entry, error) {
+	return k.update(ctx, key, func(cur Entry, exists bool) (Entry, error) {
+		if !exists {
+			return cur, errNotFound
+		}
+		if !json.Valid([]byte(cur.Value)) {
+			return cur, errNotJSON
+		}
+		doc, err := jsonpatch.MergePatch([]byte(cur.Value), patch)
+		if err != nil {
+			return cur, fmt.Errorf("%w: %v", errPatchFailed, err)
+		}
+		cur.Value = string(doc)
+		return cur, nil
+	})
+}
+
+// acceptPatch lists the patch formats PATCH understands.
+const acceptPatch = "application/json-patch+json, application/merge-patch+json"
+
+// patchKey handles PATCH on the key resource.
+func (kv *KVStore) patchKey(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read request body", 400)
+		return
+	}
+	var e Entry
+	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
+	case "application/json-patch+json":
+		patch, perr := jsonpatch.DecodePatch(body)
+		if perr != nil {
+			http.Error(w, "invalid JSON Patch", 400)
+			return
+		}
+		e, err = kv.JSONPatch(r.Context(), key, patch)
+	case "application/merge-patch+json":
+		if !json.Valid(body) {
+			http.Error(w, "invalid JSON Merge Patch", 400)
+			return
+		}
+		e, err = kv.MergePatch(r.Context(), key, body)
+	default:
+		w.Header().Set("Accept-Patch", acceptPatch)
+		http.Error(w, "unsupported patch format", 415)
+		return
+	}
+	switch {
+	case errors.Is(err, errNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errNotJSON), errors.Is(err, errPatchFailed):
+		http.Error(w, err.Error(), 409)
+	case err != nil:
+		writeStoreError(w, r, err)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, e.Value)
+	}
+}
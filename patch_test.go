@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// patchRequest sends a PATCH of key with body in the format ct to the key
+// resource.
+func patchRequest(k *KVStore, key, ct, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kv/{key...}", k.kvHandler)
+	r := httptest.NewRequest("PATCH", "/kv/"+key, strings.NewReader(body))
+	r.Header.Set("Content-Type", ct)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestJSONPatch(t *testing.T) {
+	k := newTestStore(t)
+	k.Put(context.Background(), "doc", Entry{Value: `{"status":"new","n":1}`, ContentType: "application/json"})
+	w := patchRequest(k, "doc", "application/json-patch+json",
+		`[{"op":"test","path":"/status","value":"new"},{"op":"replace","path":"/status","value":"ready"}]`)
+	if w.Code != 200 || w.Body.String() != `{"status":"ready","n":1}` {
+		t.Fatalf("patch = %d %s, want the patched document", w.Code, w.Body)
+	}
+	if e, _ := k.GetEntry("doc"); e.Value != w.Body.String() || e.ContentType != "application/json" {
+		t.Errorf("doc = %+v, want the patched document with its content type", e)
+	}
+	seq := k.Seq()
+	for _, tc := range []struct {
+		name, key, ct, body string
+		code                int
+	}{
+		{"failed test", "doc", "application/json-patch+json", `[{"op":"test","path":"/status","value":"new"}]`, 409},
+		{"missing path", "doc", "application/json-patch+json", `[{"op":"replace","path":"/x/y","value":1}]`, 409},
+		{"invalid patch", "doc", "application/json-patch+json", `{}`, 400},
+		{"missing key", "none", "application/json-patch+json", `[]`, 404},
+		{"unknown format", "doc", "application/json", `{}`, 415},
+	} {
+		if w := patchRequest(k, tc.key, tc.ct, tc.body); w.Code != tc.code {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.code)
+		}
+	}
+	k.Set(context.Background(), "text", "plain")
+	seq++
+	if w := patchRequest(k, "text", "application/json-patch+json", `[]`); w.Code != 409 {
+		t.Errorf("patch of a value that is not JSON: status %d, want 409", w.Code)
+	}
+	if k.Seq() != seq {
+		t.Error("a rejected patch was committed")
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	k := newTestStore(t)
+	k.Put(context.Background(), "doc", Entry{Value: `{"status":"new","meta":{"a":1,"b":2}}`})
+	w := patchRequest(k, "doc", "application/merge-patch+json", `{"status":"ready","meta":{"b":null,"c":3}}`)
+	if w.Code != 200 || w.Body.String() != `{"status":"ready","meta":{"a":1,"c":3}}` {
+		t.Fatalf("merge patch = %d %s, want the merged document", w.Code, w.Body)
+	}
+	if v, _ := k.Get("doc"); v != w.Body.String() {
+		t.Errorf("doc = %s, want the merged document", v)
+	}
+	if w := patchRequest(k, "doc", "application/merge-patch+json", `{"status":`); w.Code != 400 {
+		t.Errorf("invalid merge patch: status %d, want 400", w.Code)
+	}
+	if w := patchRequest(k, "none", "application/merge-patch+json", `{}`); w.Code != 404 {
+		t.Errorf("merge patch of a missing key: status %d, want 404", w.Code)
+	}
+}
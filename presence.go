@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxPresenceMeta bounds the metadata a client may attach to its
+// presence.
+const maxPresenceMeta = 4 << 10
+
+// Presence announces a named websocket client to the other subscribers
+// of its namespace.
+type Presence struct {
+	ID    uint64          `json:"id"`
+	Name  string          `json:"name"`
+	Meta  json.RawMessage `json:"meta,omitempty"`
+	Since time.Time       `json:"since"`
+}
+
+// parsePresence reads the optional name and meta (a JSON object) query
+// parameters of a websocket upgrade. Clients without a name are not part
+// of the presence set.
+func parsePresence(r *http.Request) (*Presence, error) {
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("name"))
+	if name == "" {
+		return nil, nil
+	}
+	if len(name) > 128 {
+		return nil, errors.New("name too long")
+	}
+	p := &Presence{Name: name, Since: time.Now()}
+	if meta := q.Get("meta"); meta != "" {
+		var obj map[string]any
+		if len(meta) > maxPresenceMeta || json.Unmarshal([]byte(meta), &obj) != nil {
+			return nil, errors.New("meta must be a JSON object of at most 4 KiB")
+		}
+		p.Meta = json.RawMessage(meta)
+	}
+	return p, nil
+}
+
+// Presence returns the named clients connected to namespace ns, oldest
+// first.
+func (k *KVStore) Presence(ns string) []Presence {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	out := []Presence{}
+	for _, c := range k.conns {
+		if c.ns == ns && c.presence != nil {
+			out = append(out, *c.presence)
+		}
+	}
+	return out
+}
+
+// presenceHandler lists the named clients of the namespace given by
+// ?ns=.
+func (kv *KVStore) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kv.Presence(r.URL.Query().Get("ns")))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// topicPrefix maps topics into the key space for ACLs, so a client
+// limited to "topic/alerts/" may only use topics starting with "alerts/".
+const topicPrefix = "topic/"
+
+// Publish sends message to the websocket clients subscribed to topic as
+// a "message" event. Messages are not stored and are lost for clients
+// that are not connected.
+func (k *KVStore) Publish(topic, message string) {
+	k.send(Event{Type: "message", Topic: topic, Value: message})
+}
+
+// wants reports whether client c receives ev: topic messages go to the
+// subscribers of the topic, everything else to the clients of the
+// namespace. Callers must hold k.connMu.
+func (c *wsClient) wants(ev Event) bool {
+	if ev.Topic != "" {
+		return c.topics[ev.Topic]
+	}
+	return c.ns == ev.Namespace
+}
+
+// subscribeTopic adds topic to or removes it from the subscriptions of
+// client c.
+func (k *KVStore) subscribeTopic(c *wsClient, topic string, on bool) error {
+	if !validName(topic) {
+		return errors.New("invalid topic")
+	}
+	if !c.id.mayAccess("", topicPrefix+topic) {
+		return errors.New("forbidden: topic outside the client's prefixes")
+	}
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	if on {
+		if c.topics == nil {
+			c.topics = make(map[string]bool)
+		}
+		c.topics[topic] = true
+	} else {
+		delete(c.topics, topic)
+	}
+	return nil
+}
+
+// publishHandler serves /publish?topic=, taking the message from
+// ?message= or the request body.
+func (kv *KVStore) publishHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	if !validName(topic) {
+		http.Error(w, "missing topic", 400)
+		return
+	}
+	if !allowKeys(w, r, topicPrefix+topic) {
+		return
+	}
+	message := q.Get("message")
+	if !q.Has("message") {
+		var err error
+		if message, err = readValue(r, kv.Limits().MaxValueBytes); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+	kv.Publish(topic, message)
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/theory/jsonpath"
+)
+
+// decodeJSON parses a stored JSON value, keeping numbers exact.
+func decodeJSON(value string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Query returns the nodes that the JSONPath expression p selects from
+// the JSON document stored at key.
+func (k *KVStore) Query(key string, p *jsonpath.Path) ([]any, error) {
+	e, ok := k.GetEntry(key)
+	if !ok {
+		return nil, errNotFound
+	}
+	doc, err := decodeJSON(e.Value)
+	if err != nil {
+		return nil, errNotJSON
+	}
+	return p.Select(doc), nil
+}
+
+// queryHandler serves /query?key=...&path=$.a.b. Each selected node is
+// written on its own line, strings unquoted and everything else as JSON,
+// so shell scripts can use the output directly.
+func (kv *KVStore) queryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) || q.Get("path") == "" {
+		http.Error(w, "missing key or path", 400)
+		return
+	}
+	p, err := jsonpath.Parse(q.Get("path"))
+	if err != nil {
+		http.Error(w, "invalid path: "+err.Error(), 400)
+		return
+	}
+	nodes, err := kv.Query(key, p)
+	switch {
+	case errors.Is(err, errNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 409)
+		return
+	case len(nodes) == 0:
+		http.Error(w, "no match", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, n := range nodes {
+		if s, ok := n.(string); ok {
+			fmt.Fprintln(w, s)
+			continue
+		}
+		b, _ := json.Marshal(n)
+		fmt.Fprintf(w, "%s\n", b)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Queue keys. Waiting items are kept in order in the list at
+// queuePrefix+name and delivered but unacknowledged items in the hash at
+// inflightPrefix+name, keyed by delivery ID.
+const (
+	queuePrefix    = "queue/"
+	inflightPrefix = "queue-inflight/"
+)
+
+const (
+	// defaultVisibility is how long a delivery may stay unacknowledged
+	// before its item is queued again.
+	defaultVisibility = 30 * time.Second
+	// maxDequeueWait bounds how long a dequeue may block.
+	maxDequeueWait = 5 * time.Minute
+)
+
+// errUnknownDelivery is returned when acknowledging a delivery that was
+// already acknowledged or has timed out.
+var errUnknownDelivery = errors.New("unknown delivery")
+
+// Delivery is an item handed to a consumer. It must be acknowledged with
+// its ID before the visibility timeout or it is delivered again.
+type Delivery struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// queueWaiter is a consumer blocked in Dequeue.
+type queueWaiter struct {
+	visibility time.Duration
+	ch         chan Delivery
+}
+
+// Enqueue adds value to the queue name. If consumers are waiting the item
+// is handed to the one that has waited longest.
+func (k *KVStore) Enqueue(ctx context.Context, name, value string) error {
+	return k.enqueue(ctx, name, value, false)
+}
+
+func (k *KVStore) enqueue(ctx context.Context, name, value string, front bool) error {
+	k.queueMu.Lock()
+	defer k.queueMu.Unlock()
+	if ws := k.waiters[name]; len(ws) > 0 {
+		w := ws[0]
+		k.waiters[name] = ws[1:]
+		d, err := k.deliver(ctx, name, value, w.visibility)
+		if err != nil {
+			return err
+		}
+		w.ch <- d
+		return nil
+	}
+	_, err := k.Push(ctx, queuePrefix+name, []string{value}, front)
+	return err
+}
+
+// Dequeue takes the next item of the queue name, waiting up to wait for
+// one to arrive. It reports false if the queue stayed empty. Consumers
+// are served in the order they started waiting. The item has to be
+// acknowledged within visibility.
+func (k *KVStore) Dequeue(ctx context.Context, name string, wait, visibility time.Duration) (Delivery, bool, error) {
+	k.queueMu.Lock()
+	if len(k.waiters[name]) == 0 {
+		items, err := k.Pop(ctx, queuePrefix+name, 1, true)
+		if err != nil || len(items) > 0 {
+			defer k.queueMu.Unlock()
+			if err != nil {
+				return Delivery{}, false, err
+			}
+			d, err := k.deliver(ctx, name, items[0], visibility)
+			return d, err == nil, err
+		}
+	}
+	if wait <= 0 {
+		k.queueMu.Unlock()
+		return Delivery{}, false, nil
+	}
+	w := &queueWaiter{visibility: visibility, ch: make(chan Delivery, 1)}
+	k.waiters[name] = append(k.waiters[name], w)
+	k.queueMu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case d := <-w.ch:
+		return d, true, nil
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	k.queueMu.Lock()
+	defer k.queueMu.Unlock()
+	for i, o := range k.waiters[name] {
+		if o == w {
+			k.waiters[name] = append(k.waiters[name][:i:i], k.waiters[name][i+1:]...)
+			return Delivery{}, false, ctx.Err()
+		}
+	}
+	// An item was handed over while timing out.
+	return <-w.ch, true, nil
+}
+
+// deliver records value as in flight under a new delivery ID and queues
+// it again at the front if it is not acknowledged within visibility.
+// Callers must hold k.queueMu.
+func (k *KVStore) deliver(ctx context.Context, name, value string, visibility time.Duration) (Delivery, error) {
+	d := Delivery{ID: newRequestID(), Value: value}
+	if _, err := k.HashSet(ctx, inflightPrefix+name, map[string]string{d.ID: value}); err != nil {
+		return Delivery{}, err
+	}
+	time.AfterFunc(visibility, func() {
+		if removed, _ := k.HashDelete(context.Background(), inflightPrefix+name, []string{d.ID}); len(removed) > 0 {
+			slog.Debug("queue delivery timed out", "queue", name, "id", d.ID)
+			if err := k.enqueue(context.Background(), name, value, true); err != nil {
+				slog.Error("requeue failed", "queue", name, "err", err)
+			}
+		}
+	})
+	return d, nil
+}
+
+// Ack acknowledges the delivery id of the queue name, removing its item
+// for good.
+func (k *KVStore) Ack(ctx context.Context, name, id string) error {
+	removed, err := k.HashDelete(ctx, inflightPrefix+name, []string{id})
+	if err == nil && len(removed) == 0 {
+		err = errUnknownDelivery
+	}
+	return err
+}
+
+// RecoverQueues queues the items that were in flight when the server
+// stopped again, ahead of the waiting ones.
+func (k *KVStore) RecoverQueues(ctx context.Context) error {
+	keys := k.Keys("", func(key string) bool { return strings.HasPrefix(key, inflightPrefix) })
+	for _, key := range keys {
+		h, err := k.Hash(key)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(key, inflightPrefix)
+		for id, value := range h {
+			if err := k.enqueue(ctx, name, value, true); err != nil {
+				return err
+			}
+			k.HashDelete(ctx, key, []string{id})
+		}
+	}
+	return nil
+}
+
+// queueHandler serves /queue/enqueue?name=&value=,
+// /queue/dequeue?name=&wait=&visibility= and /queue/ack?name=&id=. A
+// dequeue that finds no item within wait answers 204.
+func (kv *KVStore) queueHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if !validName(name) {
+		http.Error(w, "missing name", 400)
+		return
+	}
+	if !allowKeys(w, r, queuePrefix+name) {
+		return
+	}
+	switch r.URL.Path {
+	case "/queue/enqueue":
+		if err := kv.Enqueue(r.Context(), name, q.Get("value")); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	case "/queue/dequeue":
+		wait, visibility, err := queueTimes(q.Get("wait"), q.Get("visibility"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		d, ok, err := kv.Dequeue(r.Context(), name, wait, visibility)
+		if err != nil && r.Context().Err() == nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if !ok {
+			w.WriteHeader(204)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	case "/queue/ack":
+		if err := kv.Ack(r.Context(), name, q.Get("id")); errors.Is(err, errUnknownDelivery) {
+			http.Error(w, err.Error(), 404)
+			return
+		} else if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// queueTimes parses the wait and visibility of a dequeue.
+func queueTimes(wait, visibility string) (time.Duration, time.Duration, error) {
+	wd, err := parseTTL(wait)
+	if err != nil || wd < 0 {
+		return 0, 0, errors.New("invalid wait")
+	}
+	vd, err := parseTTL(visibility)
+	if err != nil || vd < 0 {
+		return 0, 0, errors.New("invalid visibility")
+	}
+	if vd == 0 {
+		vd = defaultVisibility
+	}
+	return min(wd, maxDequeueWait), vd, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	for _, v := range []string{"a", "b"} {
+		if err := k.Enqueue(ctx, "jobs", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, ok, err := k.Dequeue(ctx, "jobs", 0, time.Minute)
+	if err != nil || !ok || d.Value != "a" {
+		t.Fatalf("Dequeue = %+v, %v, %v; want a first", d, ok, err)
+	}
+	if err := k.Ack(ctx, "jobs", d.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Ack(ctx, "jobs", d.ID); !errors.Is(err, errUnknownDelivery) {
+		t.Errorf("second ack: error %v, want errUnknownDelivery", err)
+	}
+	if d, ok, _ := k.Dequeue(ctx, "jobs", 0, time.Minute); !ok || d.Value != "b" {
+		t.Errorf("Dequeue = %+v, %v; want b", d, ok)
+	}
+	if _, ok, err := k.Dequeue(ctx, "jobs", 10*time.Millisecond, time.Minute); ok || err != nil {
+		t.Errorf("dequeue of an empty queue = %v, %v; want nothing after the wait", ok, err)
+	}
+}
+
+func TestQueueRedelivers(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	k.Enqueue(ctx, "jobs", "a")
+	k.Enqueue(ctx, "jobs", "b")
+	first, _, _ := k.Dequeue(ctx, "jobs", 0, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	// The unacknowledged item goes back ahead of b.
+	d, ok, _ := k.Dequeue(ctx, "jobs", 0, time.Minute)
+	if !ok || d.Value != "a" || d.ID == first.ID {
+		t.Errorf("Dequeue after the visibility timeout = %+v, %v; want a under a new ID", d, ok)
+	}
+	if err := k.Ack(ctx, "jobs", first.ID); !errors.Is(err, errUnknownDelivery) {
+		t.Errorf("ack of a timed out delivery: error %v, want errUnknownDelivery", err)
+	}
+}
+
+func TestQueueWaiters(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	got := make(chan Delivery)
+	go func() {
+		d, _, _ := k.Dequeue(ctx, "jobs", time.Second, time.Minute)
+		got <- d
+	}()
+	for {
+		k.queueMu.Lock()
+		n := len(k.waiters["jobs"])
+		k.queueMu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	k.Enqueue(ctx, "jobs", "a")
+	if d := <-got; d.Value != "a" {
+		t.Errorf("waiting consumer got %+v, want a", d)
+	}
+	if l, _, _ := k.Range(queuePrefix+"jobs", 0, -1); len(l) != 0 {
+		t.Errorf("item handed to a waiting consumer was also queued: %v", l)
+	}
+}
+
+func TestRecoverQueues(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	k.HashSet(ctx, inflightPrefix+"jobs", map[string]string{"old": "a"})
+	k.Enqueue(ctx, "jobs", "b")
+	if err := k.RecoverQueues(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok, _ := k.Dequeue(ctx, "jobs", 0, time.Minute); !ok || d.Value != "a" {
+		t.Errorf("Dequeue = %+v, %v; want the recovered item a first", d, ok)
+	}
+	if err := k.Ack(ctx, "jobs", "old"); !errors.Is(err, errUnknownDelivery) {
+		t.Errorf("ack of a delivery from before the restart: error %v, want errUnknownDelivery", err)
+	}
+}
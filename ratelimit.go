@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/matst80/go-info-share/infosharepb"
+	"golang.org/x/time/rate"
+)
+
+// writeRoutes are the write endpoints that also accept GET requests.
+var writeRoutes = map[string]bool{
+	"/set": true, "/delete": true, "/cas": true, "/setnx": true, "/incr": true,
+	"/decr": true, "/append": true, "/rollback": true,
+	"/lock/acquire": true, "/lock/renew": true, "/lock/release": true,
+	"/election/campaign": true, "/election/resign": true,
+	"/lpush": true, "/rpush": true, "/lpop": true, "/rpop": true,
+	"/sadd": true, "/srem": true, "/hset": true, "/hdel": true,
+	"/queue/enqueue": true, "/queue/dequeue": true, "/queue/ack": true,
+	"/publish": true,
+}
+
+// readRoutes are the read endpoints that use POST.
+var readRoutes = map[string]bool{
+	pb.KV_Get_FullMethodName: true, pb.KV_GetAll_FullMethodName: true, pb.KV_Watch_FullMethodName: true,
+	"/get-bulk": true,
+	// GraphQL mutations are checked by graphqlHandler.
+	"/graphql": true,
+}
+
+// isWrite reports whether r may modify the store: a request to one of
+// writeRoutes or with any method other than GET, HEAD and OPTIONS.
+func isWrite(r *http.Request) bool {
+	if readRoutes[r.URL.Path] {
+		return false
+	}
+	return writeRoutes[r.URL.Path] || (r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS")
+}
+
+// rateLimiter applies a token bucket per client to write requests. A
+// client is its remote IP or, with byToken set, the API key it presents.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	byToken bool
+	clients map[string]*rate.Limiter
+	swept   time.Time
+}
+
+// limiter is the rate limiter used for all routes.
+var limiter = &rateLimiter{}
+
+// Configure sets the sustained rate in requests per second, the burst
+// size and how clients are identified ("ip" or "token"). A zero rate
+// disables limiting. Existing buckets are discarded.
+func (l *rateLimiter) Configure(perSecond float64, burst int, by string) error {
+	if by != "ip" && by != "token" {
+		return fmt.Errorf("unknown rate limit key %q", by)
+	}
+	if perSecond < 0 || burst < 0 {
+		return fmt.Errorf("rate and burst must not be negative")
+	}
+	l.mu.Lock()
+	l.rate, l.burst, l.byToken = rate.Limit(perSecond), max(burst, 1), by == "token"
+	l.clients = make(map[string]*rate.Limiter)
+	l.mu.Unlock()
+	return nil
+}
+
+// client returns the bucket key for r.
+func (l *rateLimiter) client(r *http.Request) string {
+	if key := credential(r); l.byToken && key != "" {
+		return "token " + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// reserve takes a token for client and returns how long the client has
+// to wait when none is available.
+func (l *rateLimiter) reserve(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	// Buckets that have refilled completely are the same as new ones.
+	if now.Sub(l.swept) > time.Minute {
+		for c, b := range l.clients {
+			if b.TokensAt(now) >= float64(l.burst) {
+				delete(l.clients, c)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.clients[client]
+	if !ok {
+		b = rate.NewLimiter(l.rate, l.burst)
+		l.clients[client] = b
+	}
+	res := b.ReserveN(now, 1)
+	if d := res.DelayFrom(now); d > 0 {
+		res.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+// handler rejects write requests from clients over their rate with 429
+// and a Retry-After header.
+func (l *rateLimiter) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWrite(r) && !l.allow(w, r) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token for a write by r, answering 429 and returning false
+// if its client is over the limit.
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request) bool {
+	if wait := l.reserve(l.client(r), time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "rate limit exceeded", 429)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+// readOnly is set by --read-only.
+var readOnly bool
+
+// readOnlyHandler rejects every request that could modify the store with
+// 403, leaving reads and websocket subscriptions to a store that is only
+// updated through its storage backend, e.g. a Redis replica.
+func readOnlyHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWrite(r) {
+			http.Error(w, "server is read-only", 403)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
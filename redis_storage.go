@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStorage writes every change through to a Redis hash and publishes
+// it on a channel, so several servers can share one dataset while each
+// keeps its own in-memory copy.
+type RedisStorage struct {
+	client   *redis.Client
+	hash     string
+	channel  string
+	revision string
+	origin   string
+}
+
+// redisSetMax sets KEYS[1] to ARGV[1] unless it holds a higher number:
+// each instance counts revisions of its own, and the stored revision
+// must not go back when one behind the others writes.
+const redisSetMax = `local cur = tonumber(redis.call('get', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > cur then redis.call('set', KEYS[1], ARGV[1]) end`
+
+// redisChange is the message published to other instances.
+type redisChange struct {
+	Origin string `json:"origin"`
+	Change Change `json:"change"`
+}
+
+// NewRedisStorage connects to the Redis server at url and stores data
+// under keys starting with prefix.
+func NewRedisStorage(url, prefix string) (*RedisStorage, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect redis: %w", err)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &RedisStorage{
+		client:   client,
+		hash:     prefix + ":kv",
+		channel:  prefix + ":changes",
+		revision: prefix + ":revision",
+		origin:   hex.EncodeToString(id),
+	}, nil
+}
+
+func (s *RedisStorage) Load() (map[string]Entry, error) {
+	all, err := s.client.HGetAll(context.Background(), s.hash).Result()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]Entry, len(all))
+	for k, raw := range all {
+		var e Entry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			return nil, fmt.Errorf("decode %q: %w", k, err)
+		}
+		data[k] = e
+	}
+	return data, nil
+}
+
+func (s *RedisStorage) Save(data map[string]Entry) error {
+	ctx := context.Background()
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, s.hash)
+		for k, e := range data {
+			raw, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, s.hash, k, raw)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStorage) AppendChange(c Change) error {
+	ctx := context.Background()
+	msg, err := json.Marshal(redisChange{Origin: s.origin, Change: c})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		switch c.Op {
+		case "set":
+			raw, err := json.Marshal(c.Entry)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, s.hash, c.Key, raw)
+		case "delete", "expire", "evict":
+			p.HDel(ctx, s.hash, c.Key)
+		}
+		p.Eval(ctx, redisSetMax, []string{s.revision}, c.Rev)
+		p.Publish(ctx, s.channel, msg)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStorage) Revision() (uint64, error) {
+	rev, err := s.client.Get(context.Background(), s.revision).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return rev, err
+}
+
+func (s *RedisStorage) SetRevision(rev uint64) error {
+	return s.client.Eval(context.Background(), redisSetMax, []string{s.revision}, rev).Err()
+}
+
+// Publish sends cs to the other instances, as AppendChange does for a
+// single change, without writing them to the hash.
+func (s *RedisStorage) Publish(cs []Change) error {
+	ctx := context.Background()
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, c := range cs {
+			msg, err := json.Marshal(redisChange{Origin: s.origin, Change: c})
+			if err != nil {
+				return err
+			}
+			p.Publish(ctx, s.channel, msg)
+		}
+		return nil
+	})
+	return err
+}
+
+// Watch subscribes to changes published by other instances and calls fn
+// for each of them until the client is closed.
+func (s *RedisStorage) Watch(fn func(Change)) error {
+	sub := s.client.Subscribe(context.Background(), s.channel)
+	if _, err := sub.Receive(context.Background()); err != nil {
+		sub.Close()
+		return fmt.Errorf("subscribe %s: %w", s.channel, err)
+	}
+	go func() {
+		defer sub.Close()
+		for msg := range sub.Channel() {
+			var rc redisChange
+			if err := json.Unmarshal([]byte(msg.Payload), &rc); err != nil {
+				slog.Warn("invalid redis change message", "err", err)
+				continue
+			}
+			if rc.Origin != s.origin {
+				fn(rc.Change)
+			}
+		}
+	}()
+	return nil
+}
+
+// Ping checks that the Redis server can be reached.
+func (s *RedisStorage) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Close closes the Redis client.
+func (s *RedisStorage) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnSIGHUP calls reload whenever the process receives SIGHUP.
+// Websocket clients stay connected; only the settings reload applies
+// change.
+func reloadOnSIGHUP(reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				slog.Error("reload failed", "err", err)
+				continue
+			}
+			slog.Info("configuration reloaded")
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// remoteBackupConfig configures scheduled uploads to an S3-compatible
+// bucket. GCS buckets work through their S3 interoperability endpoint
+// (storage.googleapis.com) with HMAC keys.
+type remoteBackupConfig struct {
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+	Insecure bool
+	Interval time.Duration
+	Keep     int
+}
+
+// RemoteBackup uploads gzip-compressed dumps of the store to a bucket on
+// a schedule and deletes all but the newest ones.
+type RemoteBackup struct {
+	kv     *KVStore
+	cfg    remoteBackupConfig
+	client *minio.Client
+}
+
+// NewRemoteBackup returns a RemoteBackup for cfg. Credentials are read
+// from the AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY or MINIO_ACCESS_KEY/
+// MINIO_SECRET_KEY environment variables, falling back to the instance
+// IAM role.
+func NewRemoteBackup(kv *KVStore, cfg remoteBackupConfig) (*RemoteBackup, error) {
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{},
+	})
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("backup client: %w", err)
+	}
+	return &RemoteBackup{kv: kv, cfg: cfg, client: client}, nil
+}
+
+// Run uploads a backup every interval and never returns.
+func (b *RemoteBackup) Run() {
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := b.Upload(context.Background()); err != nil {
+			slog.Error("remote backup failed", "err", err)
+		}
+	}
+}
+
+// Upload writes one backup object and applies the retention policy.
+func (b *RemoteBackup) Upload(ctx context.Context) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(b.kv.Dump()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	name := b.cfg.Prefix + "backup-" + time.Now().UTC().Format("20060102T150405Z") + ".json.gz"
+	_, err := b.client.PutObject(ctx, b.cfg.Bucket, name, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	return b.prune(ctx)
+}
+
+func (b *RemoteBackup) prune(ctx context.Context) error {
+	if b.cfg.Keep <= 0 {
+		return nil
+	}
+	var names []string
+	for obj := range b.client.ListObjects(ctx, b.cfg.Bucket, minio.ListObjectsOptions{Prefix: b.cfg.Prefix}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		base := strings.TrimPrefix(obj.Key, b.cfg.Prefix)
+		if strings.HasPrefix(base, "backup-") && strings.HasSuffix(base, ".json.gz") {
+			names = append(names, obj.Key)
+		}
+	}
+	if len(names) <= b.cfg.Keep {
+		return nil
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names[:len(names)-b.cfg.Keep] {
+		errs = append(errs, b.client.RemoveObject(ctx, b.cfg.Bucket, name, minio.RemoveObjectOptions{}))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// replayBuffer keeps the most recent change events so that websocket
+// clients reconnecting with ?last_seq= get only what they missed. It is
+// guarded by KVStore.connMu.
+type replayBuffer struct {
+	size   int
+	events []Event
+	// lost is the highest revision no longer in the buffer; clients that
+	// saw less than that need a full snapshot.
+	lost uint64
+}
+
+// add records ev, and the events of a batch, if they describe changes.
+func (b *replayBuffer) add(ev Event) {
+	if ev.Type == "batch" {
+		for _, e := range ev.Events {
+			e.Namespace = ev.Namespace
+			b.add(e)
+		}
+		return
+	}
+	if ev.Rev == 0 {
+		return
+	}
+	b.events = append(b.events, ev)
+	for len(b.events) > b.size {
+		b.lost = max(b.lost, b.events[0].Rev)
+		b.events = b.events[1:]
+	}
+}
+
+// since returns the buffered events after revision seq and reports
+// whether they are all that changed since then.
+func (b *replayBuffer) since(seq uint64) ([]Event, bool) {
+	if seq < b.lost {
+		return nil, false
+	}
+	var out []Event
+	for _, ev := range b.events {
+		if ev.Rev > seq {
+			out = append(out, ev)
+		}
+	}
+	return out, true
+}
+
+// SetReplaySize sets how many change events are kept for resuming
+// websocket clients.
+func (k *KVStore) SetReplaySize(n int) {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	k.replay.size = n
+}
+
+// lastSeq returns the ?last_seq= of a websocket upgrade.
+func lastSeq(q url.Values) (uint64, bool) {
+	n, err := strconv.ParseUint(q.Get("last_seq"), 10, 64)
+	return n, err == nil
+}
+
+// addConnResume is addConn for a client resuming after revision seq: it
+// is sent the events it may see that it missed before any new ones. If
+// those are no longer buffered, or seq is from another run of the server,
+// it gets a snapshot instead.
+func (k *KVStore) addConnResume(c *wsClient, seq uint64) {
+	cur := k.Seq()
+	k.connMu.Lock()
+	missed, ok := k.replay.since(seq)
+	if !ok || seq > cur {
+		k.connMu.Unlock()
+		k.addConnSnapshot(c)
+		return
+	}
+	var initial [][]byte
+	for _, ev := range missed {
+		if !c.wants(ev) {
+			continue
+		}
+		if ev, ok := c.filter(ev); ok {
+			initial = append(initial, c.encode(ev))
+		}
+	}
+	k.registerConn(c, initial)
+	k.connMu.Unlock()
+}
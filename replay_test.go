@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *KVStore {
+	t.Helper()
+	k, err := NewKVStore(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// writeConcurrently sets n keys from each of several goroutines.
+func writeConcurrently(t *testing.T, k *KVStore, writers, n int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n {
+				if err := k.Set(context.Background(), fmt.Sprintf("w%d/%d", w, i), "v"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReplayInCommitOrder(t *testing.T) {
+	k := newTestStore(t)
+	k.SetReplaySize(10000)
+	writeConcurrently(t, k, 8, 200)
+
+	k.connMu.Lock()
+	events := k.replay.events
+	k.connMu.Unlock()
+	if len(events) != 1600 {
+		t.Fatalf("replay holds %d events, want 1600", len(events))
+	}
+	for i, ev := range events {
+		if ev.Rev != uint64(i+1) {
+			t.Fatalf("event %d has revision %d, want %d", i, ev.Rev, i+1)
+		}
+	}
+}
+
+func TestReplaySince(t *testing.T) {
+	k := newTestStore(t)
+	k.SetReplaySize(3)
+	for i := range 5 {
+		k.Set(context.Background(), fmt.Sprint(i), "v")
+	}
+
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	missed, ok := k.replay.since(3)
+	if !ok || len(missed) != 2 || missed[0].Rev != 4 || missed[1].Rev != 5 {
+		t.Errorf("since(3) = %v, %v; want revisions 4 and 5", missed, ok)
+	}
+	if _, ok := k.replay.since(1); ok {
+		t.Error("since(1) reported complete after revision 2 was dropped")
+	}
+}
+
+func TestSinkInCommitOrder(t *testing.T) {
+	k := newTestStore(t)
+	revs := make(chan uint64, 1600)
+	k.AddSink("test", func(ev Event) { revs <- ev.Rev })
+	writeConcurrently(t, k, 8, 200)
+
+	for want := uint64(1); want <= 1600; want++ {
+		select {
+		case rev := <-revs:
+			if rev != want {
+				t.Fatalf("sink got revision %d, want %d", rev, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sink stopped before revision %d", want)
+		}
+	}
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// keyspaceChannel prefixes the pub/sub channels that carry key changes,
+// as with Redis keyspace notifications: a client subscribed to
+// __keyspace@0__:app/x receives "set", "del", "expired" and so on when
+// app/x changes. Other channels are pub/sub topics.
+const keyspaceChannel = "__keyspace@0__:"
+
+// maxLine bounds the length of a command line or bulk header.
+const maxLine = 64 << 10
+
+// maxRESPArgs bounds the number of arguments of a command.
+const maxRESPArgs = 1 << 20
+
+// Until a connection authenticates, when authentication is configured,
+// commands are limited to respAnonArgs arguments of up to respAnonBulk
+// bytes: enough for AUTH, but not for tying up memory.
+const (
+	respAnonArgs = 8
+	respAnonBulk = maxLine
+)
+
+var (
+	errRESPProtocol = errors.New("protocol error")
+	errLineTooLong  = errors.New("line too long")
+)
+
+// respError is an error reply that carries its own code, such as
+// "NOAUTH ...", rather than the default "ERR".
+type respError string
+
+// respSimple is a simple string reply such as "+OK".
+type respSimple string
+
+// respReplies are several replies to one command, as for SUBSCRIBE with
+// more than one channel.
+type respReplies []any
+
+// respSubscribedCommands are the commands allowed once a connection
+// subscribed, as in Redis.
+var respSubscribedCommands = map[string]bool{
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true,
+	"ping": true, "quit": true,
+}
+
+// respArity holds the minimum and maximum number of arguments of the
+// commands respCommand runs; -1 means no maximum.
+var respArity = map[string][2]int{
+	"ping": {0, 1}, "echo": {1, 1}, "quit": {0, 0}, "auth": {1, 2}, "select": {1, 1},
+	"command": {0, -1}, "get": {1, 1}, "set": {2, -1}, "del": {1, -1}, "exists": {1, -1},
+	"keys": {1, 1}, "incr": {1, 1}, "decr": {1, 1}, "incrby": {2, 2}, "decrby": {2, 2},
+	"publish": {2, 2}, "eval": {2, -1}, "fcall": {2, -1},
+}
+
+// respConn is a connection of the --resp-listen listener. It is the
+// clientConn of its wsClient once the connection subscribes, turning the
+// plain events it is sent into pub/sub messages.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// mu guards the writer and the subscriptions, which are written by
+	// the command loop and read by the wsClient writer.
+	mu       sync.Mutex
+	w        *bufio.Writer
+	channels map[string]bool
+	patterns map[string]*regexp.Regexp
+}
+
+func (c *respConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// WriteMessage writes the pub/sub messages for data, a plain encoded
+// event, to the channels and patterns it matches.
+func (c *respConn) WriteMessage(_ int, data []byte) error {
+	var ev Event
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.publish(ev)
+	return c.w.Flush()
+}
+
+// publish writes the messages for ev. Callers must hold c.mu.
+func (c *respConn) publish(ev Event) {
+	if ev.Type == "batch" {
+		for _, e := range ev.Events {
+			c.publish(e)
+		}
+		return
+	}
+	channel, message := ev.Topic, ev.Value
+	if ev.Topic == "" {
+		if ev.Key == "" {
+			return
+		}
+		channel, message = keyspaceChannel+ev.Key, keyspaceEvent(ev.Type)
+	}
+	if c.channels[channel] {
+		writeRESP(c.w, []any{"message", channel, message})
+	}
+	for p, re := range c.patterns {
+		if re.MatchString(channel) {
+			writeRESP(c.w, []any{"pmessage", p, channel, message})
+		}
+	}
+}
+
+// WriteControl closes the connection; RESP has no close frames.
+func (c *respConn) WriteControl(int, []byte, time.Time) error {
+	return c.conn.Close()
+}
+
+func (c *respConn) EnableWriteCompression(bool) {}
+
+func (c *respConn) Close() error { return c.conn.Close() }
+
+// reply writes v and flushes it unless more pipelined commands are
+// waiting to be read.
+func (c *respConn) reply(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rs, ok := v.(respReplies); ok {
+		for _, r := range rs {
+			writeRESP(c.w, r)
+		}
+	} else {
+		writeRESP(c.w, v)
+	}
+	if c.r.Buffered() > 0 {
+		return nil
+	}
+	return c.w.Flush()
+}
+
+// subscriptions returns the number of channels and patterns of c.
+func (c *respConn) subscriptions() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels) + len(c.patterns)
+}
+
+// keyspaceEvent names the change type t as Redis keyspace notifications
+// do where they differ.
+func keyspaceEvent(t string) string {
+	switch t {
+	case "delete":
+		return "del"
+	case "expire":
+		return "expired"
+	case "evict":
+		return "evicted"
+	}
+	return t
+}
+
+// writeRESP encodes v: strings as bulk strings, nil as the null bulk
+// string, integers, errors, respSimple and respError as their reply
+// types and slices as arrays.
+func writeRESP(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case respSimple:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", oneLine(string(v)))
+	case error:
+		fmt.Fprintf(w, "-ERR %s\r\n", oneLine(v.Error()))
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeRESP(w, s)
+		}
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeRESP(w, e)
+		}
+	default:
+		panic(fmt.Sprintf("writeRESP: unsupported type %T", v))
+	}
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// readLine reads a line terminated by "\r\n" or "\n", as used by the
+// RESP and memcached protocols.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, more, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if line = append(line, part...); len(line) > maxLine {
+			return "", errLineTooLong
+		}
+		if !more {
+			return string(line), nil
+		}
+	}
+}
+
+// readRESPCommand reads a command sent as an array of up to maxArgs bulk
+// strings of up to maxBulk bytes, as client libraries do, or as an
+// inline command line. Empty lines yield no arguments. Bulk strings are
+// read into buffers that grow as the data arrives rather than allocated
+// at the size the client announced.
+func readRESPCommand(r *bufio.Reader, maxArgs int, maxBulk int64) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, errRESPProtocol
+	}
+	args := make([]string, 0, min(max(n, 0), 64))
+	for range n {
+		h, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(h, "$") {
+			return nil, errRESPProtocol
+		}
+		size, err := strconv.ParseInt(h[1:], 10, 64)
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, errRESPProtocol
+		}
+		var b strings.Builder
+		if n, err := io.Copy(&b, io.LimitReader(r, size+2)); err != nil {
+			return nil, err
+		} else if n < size+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args = append(args, b.String()[:size])
+	}
+	return args, nil
+}
+
+// globRegexp compiles a Redis glob pattern: unlike path.Match, "*"
+// matches across "/" so that KEYS * lists every key.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("invalid pattern")
+			}
+			class := pattern[i+1 : i+1+end]
+			b.WriteByte('[')
+			if strings.HasPrefix(class, "^") || strings.HasPrefix(class, "!") {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r < utf8.RuneSelf && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					b.WriteByte('\\')
+				}
+				b.WriteRune(r)
+			}
+			b.WriteByte(']')
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// serveRESPConn runs the commands of a RESP connection. Keys are those of
+// the default namespace. Once any authentication method is configured,
+// clients have to AUTH first; writes need the write role as over the
+// websocket.
+func (k *KVStore) serveRESPConn(conn net.Conn) {
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	c := &wsClient{conn: rc, format: formatJSON}
+	registered := false
+	defer func() {
+		if registered {
+			k.removeConn(c)
+			k.releaseConn()
+		}
+		rc.mu.Lock()
+		rc.w.Flush()
+		rc.mu.Unlock()
+		conn.Close()
+	}()
+	maxBulk := k.Limits().MaxValueBytes
+	if maxBulk <= 0 {
+		maxBulk = 512 << 20
+	}
+	ctx := context.Background()
+	for {
+		maxArgs, maxLen := maxRESPArgs, maxBulk
+		if authEnabled() && c.id == nil {
+			maxArgs, maxLen = respAnonArgs, respAnonBulk
+		}
+		args, err := readRESPCommand(rc.r, maxArgs, maxLen)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) || errors.Is(err, errLineTooLong) {
+				rc.reply(respError("ERR Protocol error"))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToLower(args[0])
+		var res any
+		switch {
+		case rc.subscriptions() > 0 && !respSubscribedCommands[name]:
+			res = respError(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", name))
+		case authEnabled() && c.id == nil && name != "auth" && name != "quit":
+			res = respError("NOAUTH Authentication required.")
+		case strings.HasSuffix(name, "subscribe"):
+			if !registered && !strings.HasSuffix(name, "unsubscribe") {
+				if !k.admitConn() {
+					wsRejected.Add(1)
+					res = respError("ERR max number of clients reached")
+					break
+				}
+				k.addConn(c)
+				registered = true
+			}
+			res = k.respSubscribe(c, rc, name, args[1:])
+		default:
+			res = k.respCommand(ctx, c, rc, name, args[1:])
+		}
+		if err := rc.reply(res); err != nil || name == "quit" {
+			return
+		}
+	}
+}
+
+// respCommand runs a command other than the pub/sub ones for client c
+// and returns its reply.
+func (k *KVStore) respCommand(ctx context.Context, c *wsClient, rc *respConn, name string, args []string) any {
+	a, ok := respArity[name]
+	if !ok {
+		return respError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	if len(args) < a[0] || (a[1] >= 0 && len(args) > a[1]) {
+		return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+	switch name {
+	case "ping":
+		if rc.subscriptions() > 0 {
+			return []any{"pong", strings.Join(args, "")}
+		}
+		if len(args) == 1 {
+			return args[0]
+		}
+		return respSimple("PONG")
+	case "echo":
+		return args[0]
+	case "quit":
+		return respSimple("OK")
+	case "auth":
+		return k.respAuth(ctx, c, args)
+	case "select":
+		if args[0] != "0" {
+			return respError("ERR DB index is out of range")
+		}
+		return respSimple("OK")
+	case "command":
+		return []any{}
+	case "get":
+		if !validName(args[0]) || !c.id.mayAccess("", args[0]) {
+			return nil
+		}
+		if e, ok := k.GetEntry(args[0]); ok {
+			return e.Value
+		}
+		return nil
+	case "set":
+		return k.respSet(ctx, c, args)
+	case "del":
+		n := 0
+		for _, key := range args {
+			ik, err := c.mayWrite(key)
+			if err != nil {
+				return err
+			}
+			if k.Delete(ctx, ik) {
+				n++
+			}
+		}
+		return n
+	case "exists":
+		n := 0
+		for _, key := range args {
+			if validName(key) && c.id.mayAccess("", key) {
+				if _, ok := k.GetEntry(key); ok {
+					n++
+				}
+			}
+		}
+		return n
+	case "keys":
+		re, err := globRegexp(args[0])
+		if err != nil {
+			return err
+		}
+		return k.Keys("", func(key string) bool {
+			return re.MatchString(key) && c.id.mayAccess("", key)
+		})
+	case "incr", "decr", "incrby", "decrby":
+		by := int64(1)
+		if len(args) == 2 {
+			var err error
+			if by, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return respError("ERR value is not an integer or out of range")
+			}
+		}
+		if strings.HasPrefix(name, "decr") {
+			by = -by
+		}
+		ik, err := c.mayWrite(args[0])
+		if err != nil {
+			return err
+		}
+		n, err := k.Incr(ctx, ik, by)
+		if err != nil {
+			return err
+		}
+		return n
+	case "publish":
+		if !validName(args[0]) {
+			return errors.New("invalid topic")
+		}
+		if err := writeAccess(c.id, topicPrefix+args[0]); err != nil {
+			return err
+		}
+		k.Publish(args[0], args[1])
+		return k.topicSubscribers(args[0])
+	case "eval", "fcall":
+		return k.respEval(ctx, c, name, args)
+	}
+	return nil
+}
+
+// respEval runs EVAL script numkeys [key ...] [arg ...], or FCALL with
+// the name of a registered script in place of its source.
+func (k *KVStore) respEval(ctx context.Context, c *wsClient, name string, args []string) any {
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return respError("ERR Number of keys can't be greater than number of args")
+	}
+	var proto *lua.FunctionProto
+	if name == "fcall" {
+		proto, err = k.evalScript(args[0], "")
+	} else if !mayEvalSource(c.id) {
+		return errEvalSource
+	} else {
+		proto, err = k.evalScript("", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, identityKey{}, c.id)
+	res, err := k.Eval(ctx, "", proto, args[2:2+n], args[2+n:])
+	if err != nil {
+		return err
+	}
+	return respValue(res)
+}
+
+// respValue converts a script result like Redis does for Lua: numbers
+// become integers, true 1, false nil and objects, which Redis drops, a
+// JSON string.
+func respValue(v any) any {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return nil
+	case float64:
+		return int64(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = respValue(e)
+		}
+		return out
+	case map[string]any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return v
+}
+
+// respAuth authenticates c with an API key or bearer JWT, AUTH token, or
+// with a --basic-auth user, AUTH user password.
+func (k *KVStore) respAuth(ctx context.Context, c *wsClient, args []string) any {
+	if !authEnabled() {
+		return respError("ERR AUTH called without any password configured")
+	}
+	var user string
+	if len(args) == 2 {
+		user = args[0]
+	}
+	id, err := connIdentity(ctx, user, args[len(args)-1])
+	if err != nil {
+		slog.Info("authentication failed", "remote", c.conn.RemoteAddr().String(), "err", err)
+		return respError("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	k.connMu.Lock()
+	c.id = id
+	k.connMu.Unlock()
+	return respSimple("OK")
+}
+
+// respSet runs SET key value [EX seconds|PX milliseconds] [NX|XX].
+func (k *KVStore) respSet(ctx context.Context, c *wsClient, args []string) any {
+	ik, err := c.mayWrite(args[0])
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 == len(args) {
+				return respError("ERR syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				return respError("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	value := args[1]
+	switch {
+	case nx && xx:
+		return respError("ERR syntax error")
+	case nx:
+		ok, err := k.SetNX(ctx, ik, value, ttl)
+		if err != nil || !ok {
+			return err
+		}
+	case xx:
+		_, err := k.update(ctx, ik, func(cur Entry, exists bool) (Entry, error) {
+			if !exists {
+				return cur, errConflict
+			}
+			return newEntry(value, ttl), nil
+		})
+		if ok, err := conditional(err); err != nil || !ok {
+			return err
+		}
+	default:
+		if err := k.SetTTL(ctx, ik, value, ttl); err != nil {
+			return err
+		}
+	}
+	return respSimple("OK")
+}
+
+// respSubscribe runs SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE and PUNSUBSCRIBE
+// for client c, which is registered for broadcasts by then. Channels
+// other than keyspace channels subscribe to pub/sub topics; patterns
+// only apply to keyspace channels.
+func (k *KVStore) respSubscribe(c *wsClient, rc *respConn, name string, channels []string) any {
+	pattern := strings.HasPrefix(name, "p")
+	on := !strings.HasSuffix(name, "unsubscribe")
+	if on && len(channels) == 0 {
+		return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+	if !on && len(channels) == 0 {
+		rc.mu.Lock()
+		if pattern {
+			for p := range rc.patterns {
+				channels = append(channels, p)
+			}
+		} else {
+			for ch := range rc.channels {
+				channels = append(channels, ch)
+			}
+		}
+		rc.mu.Unlock()
+		if len(channels) == 0 {
+			return []any{name, nil, rc.subscriptions()}
+		}
+	}
+	var replies respReplies
+	for _, ch := range channels {
+		if err := k.respSubscribeOne(c, rc, ch, pattern, on); err != nil {
+			return err
+		}
+		replies = append(replies, []any{name, ch, rc.subscriptions()})
+	}
+	return replies
+}
+
+func (k *KVStore) respSubscribeOne(c *wsClient, rc *respConn, channel string, pattern, on bool) error {
+	key, keyspace := strings.CutPrefix(channel, keyspaceChannel)
+	switch {
+	case pattern && !keyspace:
+		return errors.New("patterns are only supported for " + keyspaceChannel + " channels")
+	case pattern:
+		if !on {
+			break
+		}
+		re, err := globRegexp(channel)
+		if err != nil {
+			return err
+		}
+		rc.mu.Lock()
+		if rc.patterns == nil {
+			rc.patterns = make(map[string]*regexp.Regexp)
+		}
+		rc.patterns[channel] = re
+		rc.mu.Unlock()
+		return nil
+	case keyspace && !validName(key):
+		return errors.New("invalid key")
+	case !keyspace:
+		if err := k.subscribeTopic(c, channel, on); err != nil {
+			return err
+		}
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	switch {
+	case pattern:
+		delete(rc.patterns, channel)
+	case on:
+		if rc.channels == nil {
+			rc.channels = make(map[string]bool)
+		}
+		rc.channels[channel] = true
+	default:
+		delete(rc.channels, channel)
+	}
+	return nil
+}
+
+// topicSubscribers returns the number of clients subscribed to topic.
+func (k *KVStore) topicSubscribers(topic string) int {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	n := 0
+	for _, c := range k.conns {
+		if c.topics[topic] {
+			n++
+		}
+	}
+	return n
+}
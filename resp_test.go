@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []string
+		wantErr error
+	}{
+		{in: "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", want: []string{"GET", "a"}},
+		{in: "*1\r\n$0\r\n\r\n", want: []string{""}},
+		{in: "SET a b\r\n", want: []string{"SET", "a", "b"}},
+		{in: "\r\n", want: []string{}},
+		{in: "*9\r\n", wantErr: errRESPProtocol},
+		{in: "*1\r\n$17\r\n", wantErr: errRESPProtocol},
+		{in: "*1\r\n$-1\r\n", wantErr: errRESPProtocol},
+		{in: "*1\r\n+OK\r\n", wantErr: errRESPProtocol},
+		{in: "*1\r\n$16\r\nshort\r\n", wantErr: io.ErrUnexpectedEOF},
+	} {
+		got, err := readRESPCommand(bufio.NewReader(strings.NewReader(tc.in)), 8, 16)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("readRESPCommand(%q): error %v, want %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if err == nil && !slices.Equal(got, tc.want) {
+			t.Errorf("readRESPCommand(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestReadRESPCommandPipelined(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n"))
+	for _, want := range [][]string{{"PING"}, {"ECHO", "hi"}} {
+		got, err := readRESPCommand(r, 8, 16)
+		if err != nil || !slices.Equal(got, want) {
+			t.Fatalf("readRESPCommand = %q, %v; want %q", got, err, want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxBodyBytes bounds request bodies read into memory.
+const maxBodyBytes = 1 << 20
+
+// Put stores e under key and reports whether the key was newly created.
+func (k *KVStore) Put(ctx context.Context, key string, e Entry) (created bool, err error) {
+	created, _, err = k.PutIf(ctx, key, e, nil)
+	return created, err
+}
+
+// PutIf is Put guarded by cond, failing with errPrecondition if cond
+// rejects the current entry. It also returns the revision of the write.
+func (k *KVStore) PutIf(ctx context.Context, key string, e Entry, cond precondition) (created bool, rev uint64, err error) {
+	now := time.Now()
+	c := Change{Op: "set", Key: key, Entry: e, Time: now}
+	k.mu.Lock()
+	cur, ok := k.data[key]
+	created = !ok || cur.expired(now)
+	if cond != nil && !cond(cur, !created) {
+		k.mu.Unlock()
+		return false, 0, errPrecondition
+	}
+	hooks, err := k.runSetHooks(ctx, key, &c.Entry)
+	if err == nil {
+		err = k.checkLimits(key, c.Entry)
+	}
+	if err != nil {
+		k.mu.Unlock()
+		return false, 0, err
+	}
+	rev = k.commit(ctx, &c)
+	cs := append([]Change{c}, hooks.commit()...)
+	k.unlockSending()
+	defer k.sendMu.Unlock()
+	k.broadcastChanges(cs)
+	return created, rev, nil
+}
+
+// kvHandler serves the key resource at /kv/{key} and, for namespaced
+// keys, /ns/{ns}/kv/{key}: GET returns the value as the response body,
+// PUT stores the request body, PATCH modifies a JSON value and DELETE
+// removes the key.
+func (kv *KVStore) kvHandler(w http.ResponseWriter, r *http.Request) {
+	ns, key := r.PathValue("ns"), r.PathValue("key")
+	if (r.Pattern != "/kv/{key...}" && !validName(ns)) || !validName(key) {
+		http.Error(w, "invalid namespace or key", 400)
+		return
+	}
+	ik := nsKey(ns, key)
+	if r.Method != "GET" && r.Method != "HEAD" && !allowKeys(w, r, ik) {
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		e, ok := kv.GetEntry(ik)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveValue(w, r, e)
+	case "PUT":
+		ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+		if err != nil {
+			http.Error(w, "invalid ttl", 400)
+			return
+		}
+		value, err := readValue(r, kv.Limits().MaxValueBytes)
+		if errors.Is(err, errValueTooLarge) {
+			http.Error(w, "value too large", 413)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read request body", 400)
+			return
+		}
+		e := newEntry(value, ttl)
+		e.ContentType = r.Header.Get("Content-Type")
+		created, rev, err := kv.PutIf(r.Context(), ik, e, ifMatch(r))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag(rev))
+		if created {
+			w.WriteHeader(201)
+			return
+		}
+		w.WriteHeader(204)
+	case "PATCH":
+		kv.patchKey(w, r, ik)
+	case "DELETE":
+		ok, err := kv.DeleteIf(r.Context(), ik, ifMatch(r))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(204)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+	}
+}
+
+// setParams are the arguments of a /set request.
+type setParams struct {
+	Key         string
+	Value       string
+	ContentType string
+	Type        string
+	TTL         string
+}
+
+// readSetParams collects the /set arguments from the query string and
+// the request body: a raw body of at most maxValue bytes for PUT, a JSON
+// object, or form fields. Body values take precedence over the query
+// string. On failure the error response has already been written and ok
+// is false.
+func readSetParams(w http.ResponseWriter, r *http.Request, maxValue int64) (p setParams, ok bool) {
+	q := r.URL.Query()
+	p = setParams{Key: q.Get("key"), Value: q.Get("value"), Type: q.Get("type"), TTL: q.Get("ttl")}
+	hasValue := p.Value != ""
+	if r.Method != "PUT" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	switch {
+	case r.Method == "PUT":
+		if p.Value, err = readValue(r, maxValue); err == nil {
+			hasValue = true
+			p.ContentType = r.Header.Get("Content-Type")
+		}
+	case ct == "application/json":
+		var body struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+			Type  string          `json:"type"`
+			TTL   string          `json:"ttl"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err == nil {
+			if body.Key != "" {
+				p.Key = body.Key
+			}
+			if body.TTL != "" {
+				p.TTL = body.TTL
+			}
+			if body.Type != "" {
+				p.Type = body.Type
+			}
+			if len(body.Value) > 0 {
+				// JSON strings are stored unquoted, anything else verbatim.
+				if json.Unmarshal(body.Value, &p.Value) != nil {
+					p.Value = string(body.Value)
+				}
+				hasValue = true
+			}
+		}
+	case ct == "application/x-www-form-urlencoded", ct == "multipart/form-data":
+		if ct == "multipart/form-data" {
+			err = r.ParseMultipartForm(maxBodyBytes)
+		} else {
+			err = r.ParseForm()
+		}
+		if err == nil {
+			if r.PostForm.Has("key") {
+				p.Key = r.PostForm.Get("key")
+			}
+			if r.PostForm.Has("ttl") {
+				p.TTL = r.PostForm.Get("ttl")
+			}
+			if r.PostForm.Has("type") {
+				p.Type = r.PostForm.Get("type")
+			}
+			if r.PostForm.Has("value") {
+				p.Value, hasValue = r.PostForm.Get("value"), true
+			}
+		}
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, errValueTooLarge) {
+			http.Error(w, "request body too large", 413)
+		} else {
+			http.Error(w, "invalid request body", 400)
+		}
+		return p, false
+	}
+	if p.Key == "" || !hasValue {
+		http.Error(w, "missing key or value", 400)
+		return p, false
+	}
+	return p, true
+}
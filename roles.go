@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is what an identity may do. Each role includes the ones before
+// it: readers may get and subscribe, writers may also set and delete,
+// and admins may also back up, restore and manage the server.
+type Role int
+
+// The roles, from least to most privileged.
+const (
+	RoleRead Role = iota + 1
+	RoleWrite
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{RoleRead: "read", RoleWrite: "write", RoleAdmin: "admin"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// parseRole returns the role called name.
+func parseRole(name string) (Role, error) {
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", name)
+}
+
+// defaultRole is given to API keys, users, certificates and tokens that
+// do not name a role themselves.
+var defaultRole = RoleWrite
+
+// cutRole splits an optional "read:", "write:" or "admin:" prefix off
+// a configured credential.
+func cutRole(s string) (Role, string) {
+	if name, rest, ok := strings.Cut(s, ":"); ok {
+		if r, err := parseRole(name); err == nil {
+			return r, rest
+		}
+	}
+	return defaultRole, s
+}
+
+// claimRole returns the highest role named by claim, which may be a
+// string or a list of strings, or defaultRole if it names none.
+func claimRole(claims map[string]any, claim string) Role {
+	var names []string
+	switch v := claims[claim].(type) {
+	case string:
+		names = strings.Fields(v)
+	case []any:
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	best := Role(0)
+	for _, n := range names {
+		if r, err := parseRole(n); err == nil && r > best {
+			best = r
+		}
+	}
+	if best == 0 {
+		return defaultRole
+	}
+	return best
+}
+
+// adminRoutes, and everything under /admin/ and /debug/, need the admin
+// role for any method; the registries at /schemas, /scripts and
+// /indexes need it for changes.
+var adminRoutes = map[string]bool{"/backup": true, "/restore": true, "/audit": true}
+
+// requiredRole returns the role a client needs for r.
+func requiredRole(r *http.Request) Role {
+	switch {
+	case adminRoutes[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/"):
+		return RoleAdmin
+	case (r.URL.Path == "/schemas" || r.URL.Path == "/scripts" || r.URL.Path == "/indexes") && isWrite(r):
+		return RoleAdmin
+	case isWrite(r):
+		return RoleWrite
+	}
+	return RoleRead
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// errInvalidValue is returned when a value does not validate against the
+// JSON Schema registered for its key.
+var errInvalidValue = errors.New("invalid value")
+
+type schemaRule struct {
+	raw    json.RawMessage
+	schema *jsonschema.Schema
+}
+
+// Schemas binds JSON Schemas to key patterns in path.Match syntax, e.g.
+// "config/*". Values written to a matching key must be JSON documents
+// that validate against every matching schema.
+type Schemas struct {
+	mu    sync.RWMutex
+	rules map[string]schemaRule
+}
+
+func compileSchema(pattern string, raw json.RawMessage) (*jsonschema.Schema, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(raw)))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("urn:schema", doc); err != nil {
+		return nil, err
+	}
+	return c.Compile("urn:schema")
+}
+
+// Set registers schema for pattern, replacing any earlier schema.
+func (s *Schemas) Set(pattern string, raw json.RawMessage) error {
+	sch, err := compileSchema(pattern, raw)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.rules == nil {
+		s.rules = make(map[string]schemaRule)
+	}
+	s.rules[pattern] = schemaRule{raw: raw, schema: sch}
+	s.mu.Unlock()
+	return nil
+}
+
+// Remove unregisters the schema for pattern and reports whether one
+// existed.
+func (s *Schemas) Remove(pattern string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.rules[pattern]
+	delete(s.rules, pattern)
+	return ok
+}
+
+// All returns the registered schemas by pattern.
+func (s *Schemas) All() map[string]json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]json.RawMessage, len(s.rules))
+	for p, r := range s.rules {
+		all[p] = r.raw
+	}
+	return all
+}
+
+// Load replaces the registered schemas with those in a JSON file mapping
+// key patterns to schemas. On error the current schemas are kept.
+func (s *Schemas) Load(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var rules map[string]json.RawMessage
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return err
+	}
+	next := make(map[string]schemaRule, len(rules))
+	for p, raw := range rules {
+		sch, err := compileSchema(p, raw)
+		if err != nil {
+			return fmt.Errorf("schema %q: %w", p, err)
+		}
+		next[p] = schemaRule{raw: raw, schema: sch}
+	}
+	s.mu.Lock()
+	s.rules = next
+	s.mu.Unlock()
+	return nil
+}
+
+// Validate checks value against the schemas whose pattern matches the
+// key name, ignoring its namespace.
+func (s *Schemas) Validate(key, value string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.rules) == 0 {
+		return nil
+	}
+	_, name := splitKey(key)
+	patterns := make([]string, 0, len(s.rules))
+	for p := range s.rules {
+		if ok, _ := path.Match(p, name); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	sort.Strings(patterns)
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(value))
+	if err != nil {
+		return fmt.Errorf("%w: %s is not valid JSON", errInvalidValue, name)
+	}
+	for _, p := range patterns {
+		if err := s.rules[p].schema.Validate(doc); err != nil {
+			return fmt.Errorf("%w: %s does not match schema %q: %v", errInvalidValue, name, p, err)
+		}
+	}
+	return nil
+}
+
+// schemasHandler lists schemas on GET, registers the request body as the
+// schema for ?pattern= on PUT or POST and removes it on DELETE.
+func (kv *KVStore) schemasHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kv.schemas.All())
+		return
+	case "PUT", "POST":
+		if pattern == "" {
+			http.Error(w, "missing pattern", 400)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "failed to read request body", 400)
+			return
+		}
+		if err := kv.schemas.Set(pattern, body); err != nil {
+			http.Error(w, "invalid schema: "+err.Error(), 400)
+			return
+		}
+	case "DELETE":
+		if !kv.schemas.Remove(pattern) {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,816 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// errScript is returned when a script fails or its result cannot be
+// converted.
+var errScript = errors.New("script failed")
+
+// scriptTimeout bounds a script run. Scripts run with the store locked,
+// so it also bounds how long they hold up other writes.
+var scriptTimeout = time.Second
+
+// scriptMemory bounds the bytes a script run may allocate, zero meaning
+// no limit. The process-wide allocation count is checked every
+// scriptMemoryCheck, so a run may go somewhat over before it is
+// aborted; writes, which would add to the count, wait for scripts.
+var scriptMemory int64 = 64 << 20
+
+const scriptMemoryCheck = time.Millisecond
+
+// scriptDepth bounds the nesting of tables converted to and from JSON.
+const scriptDepth = 32
+
+// scriptRepBytes bounds the strings built by string.rep, which could
+// otherwise exhaust memory in a single call, before scriptMemory is
+// checked again.
+const scriptRepBytes = maxBodyBytes
+
+// script is a registered Lua chunk. With a Pattern, in path.Match
+// syntax, it runs whenever a key matching it is set.
+type script struct {
+	Pattern string `json:"pattern,omitempty"`
+	Source  string `json:"source"`
+	// File, in --script-file only, is a file holding the source,
+	// relative to the script file.
+	File  string `json:"file,omitempty"`
+	proto *lua.FunctionProto
+}
+
+// Scripts holds the Lua scripts registered by name. A script runs either
+// on a set of a key matching its pattern, where it can validate or
+// transform the value and write further keys, or when it is called
+// through /eval like Redis EVAL. Either way it runs atomically: its
+// reads see no concurrent writes, and its own writes, made through the
+// kv table, are committed together only when it succeeds. Hooks run on
+// single-key sets and updates, bulk sets and the sets of /eval scripts;
+// list, set and hash operations are refused on hooked keys.
+type Scripts struct {
+	mu      sync.RWMutex
+	scripts map[string]script
+}
+
+func compileScript(name string, s script) (script, error) {
+	if s.Pattern != "" {
+		if _, err := path.Match(s.Pattern, ""); err != nil {
+			return s, fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+	}
+	chunk, err := parse.Parse(strings.NewReader(s.Source), name)
+	if err != nil {
+		return s, err
+	}
+	s.proto, err = lua.Compile(chunk, name)
+	return s, err
+}
+
+// Set registers source under name, replacing any earlier script, and
+// runs it on sets of keys matching pattern unless pattern is empty.
+func (s *Scripts) Set(name, pattern, source string) error {
+	sc, err := compileScript(name, script{Pattern: pattern, Source: source})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.scripts == nil {
+		s.scripts = make(map[string]script)
+	}
+	s.scripts[name] = sc
+	s.mu.Unlock()
+	return nil
+}
+
+// Remove unregisters the script name and reports whether it existed.
+func (s *Scripts) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.scripts[name]
+	delete(s.scripts, name)
+	return ok
+}
+
+// All returns the registered scripts by name.
+func (s *Scripts) All() map[string]script {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]script, len(s.scripts))
+	for name, sc := range s.scripts {
+		all[name] = script{Pattern: sc.Pattern, Source: sc.Source}
+	}
+	return all
+}
+
+// Load replaces the registered scripts with those in a JSON file mapping
+// names to {"pattern": ..., "source": ...}, or "file" in place of
+// "source". On error the current scripts are kept.
+func (s *Scripts) Load(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var scripts map[string]script
+	if err := json.Unmarshal(b, &scripts); err != nil {
+		return err
+	}
+	next := make(map[string]script, len(scripts))
+	for name, sc := range scripts {
+		if sc.File != "" {
+			src, err := os.ReadFile(filepath.Join(filepath.Dir(file), sc.File))
+			if err != nil {
+				return fmt.Errorf("script %q: %w", name, err)
+			}
+			sc.Source, sc.File = string(src), ""
+		}
+		if next[name], err = compileScript(name, sc); err != nil {
+			return fmt.Errorf("script %q: %w", name, err)
+		}
+	}
+	s.mu.Lock()
+	s.scripts = next
+	s.mu.Unlock()
+	return nil
+}
+
+// get returns the script registered as name.
+func (s *Scripts) get(name string) (*lua.FunctionProto, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sc, ok := s.scripts[name]
+	return sc.proto, ok
+}
+
+// hooks returns the names and code of the scripts whose pattern matches
+// the key name, ordered by name.
+func (s *Scripts) hooks(name string) ([]string, []*lua.FunctionProto) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for n, sc := range s.scripts {
+		if ok, _ := path.Match(sc.Pattern, name); ok && sc.Pattern != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	protos := make([]*lua.FunctionProto, len(names))
+	for i, n := range names {
+		protos[i] = s.scripts[n].proto
+	}
+	return names, protos
+}
+
+// scriptRun is a run of one or more scripts. Their writes are kept in
+// an overlay over the store until commit.
+type scriptRun struct {
+	k   *KVStore
+	ctx context.Context
+	ns  string
+	now time.Time
+	// id, if checked, is the client whose access rules reads and writes
+	// are checked against; set hooks, installed by administrators, are
+	// not restricted.
+	id      *Identity
+	checked bool
+	// skip is the key a set hook runs for, which it changes by returning
+	// a value rather than through kv.set.
+	skip string
+	// parent is the run whose writes a set hook sees, if it runs for
+	// one.
+	parent  *scriptRun
+	overlay map[string]*Entry
+	order   []string
+	// hooked holds the runs of the set hooks of the keys written.
+	hooked map[string]*scriptRun
+}
+
+func (k *KVStore) newScriptRun(ctx context.Context, ns string) *scriptRun {
+	return &scriptRun{k: k, ctx: ctx, ns: ns, now: time.Now(), id: identity(ctx), overlay: make(map[string]*Entry)}
+}
+
+// lookup returns the entry for ik as the run sees it.
+func (r *scriptRun) lookup(ik string) (Entry, bool) {
+	if e, ok := r.overlay[ik]; ok {
+		if e == nil {
+			return Entry{}, false
+		}
+		return *e, true
+	}
+	if r.parent != nil {
+		return r.parent.lookup(ik)
+	}
+	e, ok := r.k.data[ik]
+	if !ok || e.expired(r.now) {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+func (r *scriptRun) write(ik string, e *Entry) {
+	if _, ok := r.overlay[ik]; !ok {
+		r.order = append(r.order, ik)
+	}
+	r.overlay[ik] = e
+}
+
+// key returns the key named by argument n, checking that the run may
+// read it, or write it if write is set.
+func (r *scriptRun) key(L *lua.LState, n int, write bool) string {
+	name := L.CheckString(n)
+	if !validName(name) {
+		L.ArgError(n, "invalid key")
+	}
+	ik := nsKey(r.ns, name)
+	if ik == r.skip && write {
+		L.ArgError(n, "a set hook changes its key by returning the new value")
+	}
+	if r.checked {
+		var err error
+		if write {
+			err = writeAccess(r.id, ik)
+		} else if !r.id.mayAccess(r.ns, name) {
+			err = errors.New("forbidden: key outside the client's prefixes")
+		}
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+	}
+	return ik
+}
+
+// state returns a Lua state with the base, string, table and math
+// libraries, the kv and json tables and no access to files or modules.
+func (r *scriptRun) state(ctx context.Context) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 200, RegistryMaxSize: 1 << 20})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{{lua.BaseLibName, lua.OpenBase}, {lua.TabLibName, lua.OpenTable}, {lua.StringLibName, lua.OpenString}, {lua.MathLibName, lua.OpenMath}} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetField(L.GetGlobal(lua.StringLibName), "rep", L.NewFunction(func(L *lua.LState) int {
+		s, n := L.CheckString(1), L.CheckInt(2)
+		if n > 0 && len(s) > scriptRepBytes/n {
+			L.RaiseError("string.rep result over %d bytes", scriptRepBytes)
+		}
+		L.Push(lua.LString(strings.Repeat(s, max(n, 0))))
+		return 1
+	}))
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		parts := make([]string, L.GetTop())
+		for i := range parts {
+			parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+		}
+		slog.InfoContext(r.ctx, "script output", "output", strings.Join(parts, "\t"))
+		return 0
+	}))
+	L.SetGlobal("kv", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			if e, ok := r.lookup(r.key(L, 1, false)); ok {
+				L.Push(lua.LString(e.Value))
+			} else {
+				L.Push(lua.LNil)
+			}
+			return 1
+		},
+		"exists": func(L *lua.LState) int {
+			_, ok := r.lookup(r.key(L, 1, false))
+			L.Push(lua.LBool(ok))
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			ik := r.key(L, 1, true)
+			e := newEntry(L.CheckString(2), time.Duration(float64(L.OptNumber(3, 0))*float64(time.Second)))
+			r.write(ik, &e)
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			ik := r.key(L, 1, true)
+			_, ok := r.lookup(ik)
+			r.write(ik, nil)
+			L.Push(lua.LBool(ok))
+			return 1
+		},
+		"incr": func(L *lua.LState) int {
+			ik := r.key(L, 1, true)
+			cur, ok := r.lookup(ik)
+			n, err := int64(0), error(nil)
+			if ok {
+				n, err = strconv.ParseInt(cur.Value, 10, 64)
+			}
+			if err != nil {
+				L.RaiseError("%s", errNotInteger.Error())
+			}
+			n += L.OptInt64(2, 1)
+			e := newEntry(strconv.FormatInt(n, 10), 0)
+			if ok {
+				e.ExpiresAt = cur.ExpiresAt
+			}
+			r.write(ik, &e)
+			L.Push(lua.LNumber(n))
+			return 1
+		},
+		"keys": func(L *lua.LState) int {
+			glob := L.OptString(1, "*")
+			if _, err := path.Match(glob, ""); err != nil {
+				L.ArgError(1, err.Error())
+			}
+			seen := make(map[string]bool)
+			for ik := range r.k.data {
+				seen[ik] = true
+			}
+			for ik := range r.overlay {
+				seen[ik] = true
+			}
+			var keys []string
+			for ik := range seen {
+				ns, name := splitKey(ik)
+				if ok, _ := path.Match(glob, name); !ok || ns != r.ns || (r.checked && !r.id.mayAccess(ns, name)) {
+					continue
+				}
+				if _, ok := r.lookup(ik); ok {
+					keys = append(keys, name)
+				}
+			}
+			sort.Strings(keys)
+			L.Push(luaStrings(L, keys))
+			return 1
+		},
+	}))
+	L.SetGlobal("json", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"decode": func(L *lua.LState) int {
+			var v any
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+				L.RaiseError("invalid json: %s", err.Error())
+			}
+			L.Push(toLua(L, v))
+			return 1
+		},
+		"encode": func(L *lua.LState) int {
+			v, err := fromLua(L.CheckAny(1), 0)
+			if err == nil {
+				var b []byte
+				if b, err = json.Marshal(v); err == nil {
+					L.Push(lua.LString(b))
+					return 1
+				}
+			}
+			L.RaiseError("%s", err.Error())
+			return 0
+		},
+	}))
+	L.SetContext(ctx)
+	return L
+}
+
+// call runs proto with the globals set by setup and returns its first
+// result.
+func (r *scriptRun) call(proto *lua.FunctionProto, setup func(L *lua.LState)) (lua.LValue, error) {
+	ctx, cancel := context.WithTimeoutCause(r.ctx, scriptTimeout, fmt.Errorf("timed out after %s", scriptTimeout))
+	defer cancel()
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	if scriptMemory > 0 {
+		go limitAllocs(ctx, abort, allocated())
+	}
+	L := r.state(ctx)
+	defer L.Close()
+	setup(L)
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return lua.LNil, context.Cause(ctx)
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			err = errors.New(apiErr.Object.String())
+		}
+		return lua.LNil, err
+	}
+	return L.Get(-1), nil
+}
+
+// allocated returns the number of bytes the process allocated so far.
+func allocated() uint64 {
+	s := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(s)
+	return s[0].Value.Uint64()
+}
+
+// limitAllocs aborts a script run once allocations have grown by more
+// than scriptMemory bytes from start, checking until ctx is done.
+func limitAllocs(ctx context.Context, abort context.CancelCauseFunc, start uint64) {
+	t := time.NewTicker(scriptMemoryCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if allocated()-start > uint64(scriptMemory) {
+				abort(fmt.Errorf("allocated over %d bytes", scriptMemory))
+				return
+			}
+		}
+	}
+}
+
+// check reports whether committing the writes of the run and of the
+// hooks they ran, along with the entries in extra, would exceed the
+// store limits. Deletions are not credited against the key count.
+// Callers must hold k.mu.
+func (r *scriptRun) check(extra map[string]Entry) error {
+	entries := make(map[string]Entry, len(extra))
+	for ik, e := range extra {
+		entries[ik] = e
+	}
+	r.sets(entries)
+	values := make(map[string]string, len(entries))
+	for ik, e := range entries {
+		if err := r.k.checkLimits(ik, e); err != nil {
+			return err
+		}
+		values[ik] = e.Value
+	}
+	return r.k.checkBulkLimits(values)
+}
+
+// sets adds the entries the run and the hooks it ran set to entries.
+func (r *scriptRun) sets(entries map[string]Entry) {
+	for _, ik := range r.order {
+		if e := r.overlay[ik]; e != nil {
+			entries[ik] = *e
+		}
+		if hr := r.hooked[ik]; hr != nil {
+			hr.sets(entries)
+		}
+	}
+}
+
+// commit commits the writes of the run, which check has accepted, in the
+// order the keys were first written, each followed by the writes of its
+// set hooks. Callers must hold k.mu and broadcast the returned changes.
+func (r *scriptRun) commit() []Change {
+	if r == nil {
+		return nil
+	}
+	var cs []Change
+	for _, ik := range r.order {
+		c := Change{Op: "set", Key: ik, Time: r.now}
+		if e := r.overlay[ik]; e != nil {
+			c.Entry = *e
+		} else if cur, ok := r.k.data[ik]; ok && !cur.expired(r.now) {
+			c.Op = "delete"
+		} else {
+			continue
+		}
+		r.k.commit(r.ctx, &c)
+		cs = append(cs, c)
+		cs = append(cs, r.hooked[ik].commit()...)
+	}
+	return cs
+}
+
+// runHooks runs the set hooks of every key the run sets.
+func (r *scriptRun) runHooks() error {
+	for _, ik := range r.order {
+		e := r.overlay[ik]
+		if e == nil {
+			continue
+		}
+		hr, err := r.k.setHooks(r.ctx, r, ik, e)
+		if err != nil {
+			return err
+		}
+		if hr != nil {
+			if r.hooked == nil {
+				r.hooked = make(map[string]*scriptRun)
+			}
+			r.hooked[ik] = hr
+		}
+	}
+	return nil
+}
+
+// setHooks runs the scripts whose pattern matches ik with the entry e
+// about to be stored under it, as KEY, VALUE and OLD, the current value
+// or nil. A script rejects the value by raising an error, replaces it by
+// returning a string and may write other keys. The writes are kept in
+// the returned run, nil if no script matches, on top of those of parent
+// if it is not nil; writes of hooks do not run hooks themselves.
+// Callers must hold k.mu.
+func (k *KVStore) setHooks(ctx context.Context, parent *scriptRun, ik string, e *Entry) (*scriptRun, error) {
+	ns, name := splitKey(ik)
+	names, protos := k.scripts.hooks(name)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	r := k.newScriptRun(ctx, ns)
+	r.parent, r.skip = parent, ik
+	old := lua.LValue(lua.LNil)
+	if cur, ok := k.data[ik]; ok && !cur.expired(r.now) {
+		old = lua.LString(cur.Value)
+	}
+	for i, proto := range protos {
+		res, err := r.call(proto, func(L *lua.LState) {
+			L.SetGlobal("KEY", lua.LString(name))
+			L.SetGlobal("VALUE", lua.LString(e.Value))
+			L.SetGlobal("OLD", old)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: script %s: %v", errInvalidValue, names[i], err)
+		}
+		if s, ok := res.(lua.LString); ok {
+			e.Value = string(s)
+		}
+	}
+	return r, nil
+}
+
+// runSetHooks runs the set hooks of key for e, a single-key set about
+// to be committed, and checks the limits for e together with the keys
+// the hooks write. Callers must hold k.mu, commit the returned run right
+// after key and broadcast its changes with it.
+func (k *KVStore) runSetHooks(ctx context.Context, key string, e *Entry) (*scriptRun, error) {
+	hr, err := k.setHooks(ctx, nil, key, e)
+	if err != nil || hr == nil {
+		return nil, err
+	}
+	return hr, hr.check(map[string]Entry{key: *e})
+}
+
+// refuseHooked fails for key if it has set hooks. Writes that change a
+// key other than by setting its value, such as list and hash
+// operations, use it since the hooks could not see what they store.
+func (k *KVStore) refuseHooked(key string) error {
+	_, name := splitKey(key)
+	if names, _ := k.scripts.hooks(name); len(names) > 0 {
+		return fmt.Errorf("%w: %s has set hooks and must be written with a set", errInvalidValue, name)
+	}
+	return nil
+}
+
+// Eval runs proto atomically with the keys and arguments as KEYS and
+// ARGV, in namespace ns and with the access rules of the client in ctx,
+// and returns its result converted to JSON values. The keys the script
+// sets run their set hooks.
+func (k *KVStore) Eval(ctx context.Context, ns string, proto *lua.FunctionProto, keys, args []string) (any, error) {
+	k.mu.Lock()
+	r := k.newScriptRun(ctx, ns)
+	r.checked = true
+	res, err := r.call(proto, func(L *lua.LState) {
+		L.SetGlobal("KEYS", luaStrings(L, keys))
+		L.SetGlobal("ARGV", luaStrings(L, args))
+	})
+	var cs []Change
+	if err != nil {
+		err = fmt.Errorf("%w: %v", errScript, err)
+	} else if err = r.runHooks(); err == nil {
+		if err = r.check(nil); err == nil {
+			cs = r.commit()
+		}
+	}
+	k.unlockSending()
+	if len(cs) > 0 {
+		k.broadcastChanges(cs)
+	}
+	k.sendMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	v, err := fromLua(res, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errScript, err)
+	}
+	return v, nil
+}
+
+// luaStrings returns a Lua array of ss.
+func luaStrings(L *lua.LState, ss []string) *lua.LTable {
+	t := L.CreateTable(len(ss), 0)
+	for _, s := range ss {
+		t.Append(lua.LString(s))
+	}
+	return t
+}
+
+// toLua converts a decoded JSON value to Lua.
+func toLua(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []any:
+		t := L.CreateTable(len(v), 0)
+		for _, e := range v {
+			t.Append(toLua(L, e))
+		}
+		return t
+	case map[string]any:
+		t := L.CreateTable(0, len(v))
+		for name, e := range v {
+			t.RawSetString(name, toLua(L, e))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts a Lua value to one encoding/json can marshal: tables
+// with keys 1..n become arrays, other tables objects, and integral
+// numbers integers.
+func fromLua(v lua.LValue, depth int) (any, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, errors.New("number is not finite")
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f), nil
+		}
+		return f, nil
+	case *lua.LTable:
+		if depth >= scriptDepth {
+			return nil, errors.New("tables nested too deeply")
+		}
+		if n := v.MaxN(); n > 0 && n == v.Len() {
+			arr := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				e, err := fromLua(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, e)
+			}
+			return arr, nil
+		}
+		obj := make(map[string]any)
+		var err error
+		v.ForEach(func(key, value lua.LValue) {
+			if err == nil {
+				obj[key.String()], err = fromLua(value, depth+1)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			return []any{}, nil
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("cannot convert a %s", v.Type())
+}
+
+// evalRequest is the body of a POST to /eval: the name of a registered
+// script or the source of one, which mayEvalSource allows, and its KEYS
+// and ARGV.
+type evalRequest struct {
+	Script string   `json:"script"`
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+	Args   []string `json:"args"`
+}
+
+// evalHandler runs a script at /eval and /ns/{ns}/eval, responding
+// with {"result": ...}.
+func (kv *KVStore) evalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	ns := r.PathValue("ns")
+	if r.Pattern != "/eval" && !validName(ns) {
+		http.Error(w, "invalid namespace", 400)
+		return
+	}
+	var req evalRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	if req.Script == "" && !mayEvalSource(identity(r.Context())) {
+		http.Error(w, errEvalSource.Error(), 403)
+		return
+	}
+	proto, err := kv.evalScript(req.Script, req.Source)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := kv.Eval(r.Context(), ns, proto, req.Keys, req.Args)
+	if errors.Is(err, errScript) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"result": res})
+}
+
+// errEvalSource is returned to clients that may only run registered
+// scripts.
+var errEvalSource = errors.New("forbidden: running a script source needs the admin role")
+
+// mayEvalSource reports whether id may run a script source rather than a
+// registered script. Sources run with the store locked, so once
+// authentication is configured only administrators may send them.
+func mayEvalSource(id *Identity) bool {
+	return !authEnabled() || id != nil && id.Role >= RoleAdmin
+}
+
+// evalScript returns the registered script name, or compiles source if
+// name is empty.
+func (kv *KVStore) evalScript(name, source string) (*lua.FunctionProto, error) {
+	if name != "" {
+		proto, ok := kv.scripts.get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown script %q", name)
+		}
+		return proto, nil
+	}
+	if source == "" {
+		return nil, errors.New("missing script or source")
+	}
+	sc, err := compileScript("eval", script{Source: source})
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %w", err)
+	}
+	return sc.proto, nil
+}
+
+// scriptsHandler lists scripts on GET, registers the request body as
+// the script ?name=, run on sets of keys matching ?pattern= if given, on
+// PUT or POST and removes it on DELETE.
+func (kv *KVStore) scriptsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kv.scripts.All())
+		return
+	case "PUT", "POST":
+		if name == "" {
+			http.Error(w, "missing name", 400)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "failed to read request body", 400)
+			return
+		}
+		if err := kv.scripts.Set(name, r.URL.Query().Get("pattern"), string(body)); err != nil {
+			http.Error(w, "invalid script: "+err.Error(), 400)
+			return
+		}
+	case "DELETE":
+		if !kv.scripts.Remove(name) {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// eval runs source as an ad-hoc script in the default namespace.
+func eval(t *testing.T, k *KVStore, source string, keys, args []string) (any, error) {
+	t.Helper()
+	proto, err := k.evalScript("", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.Eval(context.Background(), "", proto, keys, args)
+}
+
+func TestEval(t *testing.T) {
+	k := newTestStore(t)
+	k.Set(context.Background(), "a", "5")
+	res, err := eval(t, k, `kv.set("b", ARGV[1]) return kv.incr(KEYS[1], 2)`, []string{"a"}, []string{"x"})
+	if err != nil || fmt.Sprint(res) != "7" {
+		t.Fatalf("eval = %v, %v; want 7", res, err)
+	}
+	if v, _ := k.Get("b"); v != "x" {
+		t.Errorf("b = %q, want x", v)
+	}
+}
+
+func TestEvalFailureWritesNothing(t *testing.T) {
+	k := newTestStore(t)
+	seq := k.Seq()
+	_, err := eval(t, k, `kv.set("a", "1") error("boom")`, nil, nil)
+	if !errors.Is(err, errScript) || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("eval error = %v, want the script's error", err)
+	}
+	if _, ok := k.Get("a"); ok || k.Seq() != seq {
+		t.Error("a failed script committed its writes")
+	}
+}
+
+func TestSetHooks(t *testing.T) {
+	k := newTestStore(t)
+	ctx := context.Background()
+	if err := k.scripts.Set("orders", "orders/*", `
+		local o = json.decode(VALUE)
+		if o.total < 0 then error("negative total") end
+		kv.incr("stats/orders")
+		o.checked = true
+		return json.encode(o)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Set(ctx, "orders/1", `{"total":3}`); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("orders/1"); v != `{"checked":true,"total":3}` {
+		t.Errorf("orders/1 = %s, want the hook's value", v)
+	}
+	err := k.Set(ctx, "orders/2", `{"total":-1}`)
+	if !errors.Is(err, errInvalidValue) {
+		t.Errorf("set rejected by its hook: error %v, want errInvalidValue", err)
+	}
+	if err := k.SetBulk(ctx, map[string]string{"orders/3": `{"total":1}`, "other": "x"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eval(t, k, `kv.set("orders/4", '{"total":2}')`, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("stats/orders"); v != "3" {
+		t.Errorf("stats/orders = %s, want 3: hooks of set, bulk set and eval", v)
+	}
+	if _, err := k.Push(ctx, "orders/5", []string{"x"}, false); !errors.Is(err, errInvalidValue) {
+		t.Errorf("push onto a hooked key: error %v, want errInvalidValue", err)
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	k := newTestStore(t)
+	for name, source := range map[string]string{
+		"rep":    `return string.rep("x", 1e9)`,
+		"concat": `local s = "x" for i = 1, 40 do s = s .. s end return #s`,
+		"table":  `local t = {} for i = 1, 1e9 do t[i] = tostring(i) end return #t`,
+	} {
+		if _, err := eval(t, k, source, nil, nil); err == nil || !strings.Contains(err.Error(), "bytes") {
+			t.Errorf("%s: script exhausting memory returned %v, want a memory limit error", name, err)
+		}
+	}
+	if res, err := eval(t, k, `return #string.rep("ab", 3)`, nil, nil); err != nil || fmt.Sprint(res) != "6" {
+		t.Errorf("string.rep = %v, %v; want 6", res, err)
+	}
+}
+
+func TestMayEvalSource(t *testing.T) {
+	if !mayEvalSource(nil) {
+		t.Error("source refused with authentication off")
+	}
+	withAPIKeys(t, "write:wkey")
+	if mayEvalSource(nil) || mayEvalSource(&Identity{Role: RoleWrite}) {
+		t.Error("source allowed for a client that is not an admin")
+	}
+	if !mayEvalSource(&Identity{Role: RoleAdmin}) {
+		t.Error("source refused for an admin")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchIndex is an inverted index from lower-cased words to the keys
+// whose values contain them.
+type searchIndex struct {
+	words map[string]map[string]struct{}
+	byKey map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{words: make(map[string]map[string]struct{}), byKey: make(map[string][]string)}
+}
+
+// tokenize splits s into distinct lower-cased words of letters and
+// digits.
+func tokenize(s string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+func (s *searchIndex) remove(key string) {
+	for _, w := range s.byKey[key] {
+		delete(s.words[w], key)
+		if len(s.words[w]) == 0 {
+			delete(s.words, w)
+		}
+	}
+	delete(s.byKey, key)
+}
+
+func (s *searchIndex) add(key string, e Entry) {
+	if e.binary() {
+		return
+	}
+	words := tokenize(e.Value)
+	s.byKey[key] = words
+	for _, w := range words {
+		if s.words[w] == nil {
+			s.words[w] = make(map[string]struct{})
+		}
+		s.words[w][key] = struct{}{}
+	}
+}
+
+// EnableSearch builds the full-text index from the current data and
+// keeps it up to date from then on.
+func (k *KVStore) EnableSearch() {
+	k.mu.Lock()
+	k.search = newSearchIndex()
+	for key, e := range k.data {
+		k.search.add(key, e)
+	}
+	k.mu.Unlock()
+}
+
+// Search returns the sorted live keys in namespace ns whose values
+// contain q, ignoring case. The words of q may be parts of the words of
+// a value, so the inverted index narrows the candidates to the values
+// holding a word that contains the longest word of q; queries without
+// letters or digits check every value. ok is false if search is
+// disabled.
+func (k *KVStore) Search(ns, q string) (keys []string, ok bool) {
+	now := time.Now()
+	keys = make([]string, 0)
+	words := tokenize(q)
+	needle := strings.ToLower(q)
+	k.mu.RLock()
+	defer func() {
+		k.mu.RUnlock()
+		sort.Strings(keys)
+	}()
+	if k.search == nil {
+		return keys, false
+	}
+	candidates := make(map[string]struct{})
+	if len(words) == 0 {
+		for ik := range k.data {
+			candidates[ik] = struct{}{}
+		}
+	} else {
+		longest := words[0]
+		for _, w := range words[1:] {
+			if len(w) > len(longest) {
+				longest = w
+			}
+		}
+		for w, iks := range k.search.words {
+			if strings.Contains(w, longest) {
+				for ik := range iks {
+					candidates[ik] = struct{}{}
+				}
+			}
+		}
+	}
+	for ik := range candidates {
+		e := k.data[ik]
+		if e.expired(now) || e.binary() || !strings.Contains(strings.ToLower(e.Value), needle) {
+			continue
+		}
+		if n, key := splitKey(ik); n == ns {
+			keys = append(keys, key)
+		}
+	}
+	return keys, true
+}
+
+func (kv *KVStore) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing q", 400)
+		return
+	}
+	keys, ok := kv.Search("", q)
+	if !ok {
+		http.Error(w, "search is disabled", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
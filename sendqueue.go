@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultSendBuffer is the number of messages queued per websocket
+// client before it counts as too slow and is disconnected.
+const defaultSendBuffer = 256
+
+// compressMin is the smallest message compressed on connections that
+// negotiated permessage-deflate; below it deflate costs more CPU than it
+// saves in bandwidth.
+const compressMin = 512
+
+// SetSendBuffer sets the per-connection send queue length for clients
+// connecting from now on.
+func (k *KVStore) SetSendBuffer(n int) {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	k.sendBuffer = n
+}
+
+// Queued returns the number of messages waiting in the send queues of
+// all websocket clients.
+func (k *KVStore) Queued() int {
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	n := 0
+	for _, c := range k.conns {
+		n += len(c.out)
+	}
+	return n
+}
+
+// startWriter gives c its send queue, holding initial ahead of anything
+// else, and starts the goroutine that writes the queue to the
+// connection. Callers must hold k.connMu.
+func (k *KVStore) startWriter(c *wsClient, initial [][]byte) {
+	size := k.sendBuffer
+	if size <= 0 {
+		size = defaultSendBuffer
+	}
+	c.out = make(chan []byte, size+len(initial))
+	for _, msg := range initial {
+		c.out <- msg
+	}
+	go k.writer(c)
+}
+
+// writer writes the queued messages of c until the queue is closed by
+// removeConn. After a failed write the connection is closed and the rest
+// of the queue is discarded.
+func (k *KVStore) writer(c *wsClient) {
+	failed := false
+	for msg := range c.out {
+		if failed {
+			continue
+		}
+		start := time.Now()
+		c.conn.EnableWriteCompression(len(msg) >= compressMin)
+		err := c.conn.WriteMessage(c.messageType(), msg)
+		k.connMu.Lock()
+		c.lastWrite = time.Since(start)
+		c.sent++
+		if err != nil {
+			c.errors++
+		}
+		k.connMu.Unlock()
+		if err != nil {
+			failed = true
+			broadcastErrors.Add(1)
+			slog.Warn("websocket write failed", "remote", c.conn.RemoteAddr().String(), "err", err)
+			c.conn.Close()
+		}
+	}
+}
+
+// queue hands msg to the writer of c without blocking. A client whose
+// queue is full is disconnected as a slow consumer rather than holding
+// up everyone else. Callers must hold k.connMu.
+func (k *KVStore) queue(c *wsClient, msg []byte) bool {
+	if c.out == nil || c.closed {
+		return false
+	}
+	select {
+	case c.out <- msg:
+		return true
+	default:
+	}
+	if !c.slow {
+		c.slow = true
+		wsSlowConsumers.Add(1)
+		slog.Warn("disconnecting slow websocket client", "remote", c.conn.RemoteAddr().String(), "queued", len(c.out))
+		go func() {
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer")
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.conn.Close()
+		}()
+	}
+	return false
+}
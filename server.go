@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverConfig describes how the HTTP server is exposed.
+type serverConfig struct {
+	// Listen holds the addresses to serve on: host:port for TCP or
+	// unix:///path/to.sock for a unix domain socket.
+	Listen  []string
+	TLSCert string
+	TLSKey  string
+
+	// ACMEDomains enables automatic certificates from an ACME CA such as
+	// Let's Encrypt for the listed host names.
+	ACMEDomains []string
+	ACMECache   string
+	ACMEEmail   string
+	// ACMEHTTP is the address of the plain HTTP listener that answers
+	// HTTP-01 challenges and redirects everything else to HTTPS.
+	ACMEHTTP string
+
+	// ClientCA enables mutual TLS with client certificates signed by the
+	// CAs in this file; ClientAuth is "require" or "optional".
+	ClientCA   string
+	ClientAuth string
+}
+
+// shutdown stops srv from accepting connections, closes all websocket
+// clients with a "server shutting down" reason, waits up to timeout for
+// in-flight requests, runs stops in reverse order to close the other
+// listeners and the bridges and finally flushes the store's storage
+// backend.
+func shutdown(srv *http.Server, kv *KVStore, timeout time.Duration, stops ...func()) error {
+	slog.Info("server shutting down")
+	kv.SetReady(false)
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(ctx) }()
+	kv.CloseConns("server shutting down")
+	if err := <-done; err != nil {
+		slog.Warn("drain timeout exceeded, closing remaining connections", "err", err)
+		srv.Close()
+	}
+	for i := len(stops) - 1; i >= 0; i-- {
+		stops[i]()
+	}
+	return kv.Close()
+}
+
+// listen opens a listener for a --listen address. A stale socket file
+// left behind by an earlier run is removed first.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// protocolListener is a listener of serveProtocol that tracks the
+// connections it accepted.
+type protocolListener struct {
+	net.Listener
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// the commands they were running to finish.
+func (l *protocolListener) Close() error {
+	err := l.Listener.Close()
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	return err
+}
+
+// serveProtocol listens on addr and runs serve for every connection
+// accepted, for a protocol other than HTTP such as "resp", until the
+// returned listener is closed.
+func serveProtocol(addr, protocol string, serve func(net.Conn)) (*protocolListener, error) {
+	ln, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(protocol+" listener listening", "addr", ln.Addr().String())
+	l := &protocolListener{Listener: ln, conns: make(map[net.Conn]struct{})}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error(protocol+" listener failed", "err", err)
+				}
+				return
+			}
+			l.mu.Lock()
+			if l.closed {
+				l.mu.Unlock()
+				conn.Close()
+				return
+			}
+			l.conns[conn] = struct{}{}
+			l.wg.Add(1)
+			l.mu.Unlock()
+			go func() {
+				defer l.wg.Done()
+				serve(conn)
+				l.mu.Lock()
+				delete(l.conns, conn)
+				l.mu.Unlock()
+			}()
+		}
+	}()
+	return l, nil
+}
+
+// serve runs srv on every address in cfg.Listen, or on the sockets
+// passed by systemd socket activation, until one of them fails. It
+// serves HTTPS when a certificate and key or ACME domains are configured
+// and marks kv ready, also towards systemd, once all listeners are open.
+func serve(srv *http.Server, kv *KVStore, cfg serverConfig) error {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+	if len(cfg.ACMEDomains) > 0 {
+		if cfg.TLSCert != "" {
+			return errors.New("--acme-domain cannot be combined with --tls-cert")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECache),
+			Email:      cfg.ACMEEmail,
+		}
+		go func() {
+			slog.Info("ACME challenge listener started", "addr", cfg.ACMEHTTP)
+			if err := http.ListenAndServe(cfg.ACMEHTTP, m.HTTPHandler(nil)); err != nil {
+				slog.Error("ACME challenge listener failed", "err", err)
+			}
+		}()
+		srv.TLSConfig = m.TLSConfig()
+	}
+	if cfg.ClientCA != "" {
+		if cfg.TLSCert == "" && len(cfg.ACMEDomains) == 0 {
+			return errors.New("--tls-client-ca needs --tls-cert or --acme-domain")
+		}
+		if err := setupClientAuth(srv, cfg.ClientCA, cfg.ClientAuth); err != nil {
+			return err
+		}
+	}
+	lns, err := systemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(lns) == 0 {
+		for _, addr := range cfg.Listen {
+			ln, err := listen(addr)
+			if err != nil {
+				return err
+			}
+			lns = append(lns, ln)
+		}
+	}
+	if len(lns) == 0 {
+		return errors.New("no --listen address")
+	}
+	tls := srv.TLSConfig != nil || cfg.TLSCert != ""
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		slog.Info("server listening", "addr", ln.Addr().String())
+		go func() {
+			if tls {
+				errc <- srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+			} else {
+				errc <- srv.Serve(ln)
+			}
+		}()
+	}
+	kv.SetReady(true)
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("sd_notify failed", "err", err)
+	}
+	return <-errc
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// setOf decodes the members of the set stored in e. A missing key is an
+// empty set.
+func setOf(e Entry, exists bool) (map[string]bool, error) {
+	members := make(map[string]bool)
+	if !exists {
+		return members, nil
+	}
+	var items []string
+	if e.Type != "set" || json.Unmarshal([]byte(e.Value), &items) != nil {
+		return nil, fmt.Errorf("%w: want set", errWrongType)
+	}
+	for _, m := range items {
+		members[m] = true
+	}
+	return members, nil
+}
+
+// withSet returns e holding members as a set, keeping its expiry. The
+// members are stored as a sorted JSON array.
+func withSet(e Entry, members map[string]bool) Entry {
+	data, _ := json.Marshal(sortedMembers(members))
+	e.Value, e.Type, e.ContentType = string(data), "set", ""
+	return e
+}
+
+// SetAdd adds members to the set at key, creating it if needed, and
+// returns the members that were not present yet. Subscribers get an
+// "sadd" event with those members.
+func (k *KVStore) SetAdd(ctx context.Context, key string, members []string) ([]string, error) {
+	return k.changeSet(ctx, key, members, true)
+}
+
+// SetRemove removes members from the set at key and returns the members
+// that were present. The key is deleted once the set is empty.
+// Subscribers get an "srem" event with the removed members.
+func (k *KVStore) SetRemove(ctx context.Context, key string, members []string) ([]string, error) {
+	return k.changeSet(ctx, key, members, false)
+}
+
+func (k *KVStore) changeSet(ctx context.Context, key string, members []string, add bool) ([]string, error) {
+	var changed []string
+	c, err := k.mutate(ctx, key, func(cur Entry, exists bool) (Entry, bool, error) {
+		set, err := setOf(cur, exists)
+		if err != nil {
+			return cur, false, err
+		}
+		changed = changed[:0]
+		for _, m := range members {
+			if set[m] != add {
+				set[m] = add
+				changed = append(changed, m)
+			}
+		}
+		if !add {
+			for _, m := range changed {
+				delete(set, m)
+			}
+		}
+		if len(changed) == 0 {
+			return cur, false, errNoChange
+		}
+		return withSet(cur, set), len(set) == 0, nil
+	})
+	if errors.Is(err, errNoChange) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	op := "srem"
+	if add {
+		op = "sadd"
+	}
+	defer k.sendMu.Unlock()
+	k.broadcast(c.stamp(Event{Type: op, Key: key, Values: changed}))
+	return changed, nil
+}
+
+// Members returns the sorted members of the set at key.
+func (k *KVStore) Members(key string) ([]string, error) {
+	e, ok := k.GetEntry(key)
+	set, err := setOf(e, ok)
+	if err != nil {
+		return nil, err
+	}
+	return sortedMembers(set), nil
+}
+
+func sortedMembers(set map[string]bool) []string {
+	items := make([]string, 0, len(set))
+	for m := range set {
+		items = append(items, m)
+	}
+	slices.Sort(items)
+	return items
+}
+
+// setsHandler serves the set operations:
+//
+//	/sadd, /srem?key=&member=...   add or remove members, returns the changed ones
+//	/smembers?key=                 the sorted members as JSON
+//	/sismember?key=&member=        true or false
+//	/scard?key=                    number of members
+func (kv *KVStore) setsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if !validName(key) {
+		http.Error(w, "missing key", 400)
+		return
+	}
+	op := r.URL.Path[1:]
+	members := q["member"]
+	var res any
+	var err error
+	switch op {
+	case "sadd", "srem":
+		if len(members) == 0 {
+			http.Error(w, "missing member", 400)
+			return
+		}
+		if !allowKeys(w, r, key) {
+			return
+		}
+		if op == "sadd" {
+			res, err = kv.SetAdd(r.Context(), key, members)
+		} else {
+			res, err = kv.SetRemove(r.Context(), key, members)
+		}
+	case "smembers", "sismember", "scard":
+		var all []string
+		if all, err = kv.Members(key); err != nil {
+			break
+		}
+		switch op {
+		case "smembers":
+			res = all
+		case "sismember":
+			_, res = slices.BinarySearch(all, q.Get("member"))
+		case "scard":
+			res = len(all)
+		}
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
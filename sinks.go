@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"log/slog"
+)
+
+// sinkBuffer is the number of events queued for a sink; further events
+// are dropped until it catches up.
+const sinkBuffer = 4096
+
+// sink hands key change events to an external system, such as the MQTT
+// bridge, on a goroutine of its own so a slow broker does not hold up
+// the broadcast.
+type sink struct {
+	name    string
+	events  chan Event
+	deliver func(Event)
+	// match, if set, selects the events the sink takes, and drop is
+	// called with those it fell too far behind to take.
+	match func(Event) bool
+	drop  func(Event)
+}
+
+// AddSink calls deliver with every change of a key, in order and one at
+// a time; batches are split into their events, and events that are not
+// store changes, such as topic messages, are left out. Events that
+// arrive while sinkBuffer events are waiting are dropped and logged
+// under name.
+func (k *KVStore) AddSink(name string, deliver func(Event)) {
+	k.addSink(&sink{name: name, deliver: deliver})
+}
+
+// addSink starts s.
+func (k *KVStore) addSink(s *sink) {
+	s.events = make(chan Event, sinkBuffer)
+	go func() {
+		for ev := range s.events {
+			s.deliver(ev)
+		}
+	}()
+	k.connMu.Lock()
+	k.sinks = append(k.sinks, s)
+	k.connMu.Unlock()
+}
+
+// notifySinks queues ev for every sink. Callers must hold k.connMu.
+func (k *KVStore) notifySinks(ev Event) {
+	if ev.Type == "batch" {
+		for _, e := range ev.Events {
+			e.Namespace = ev.Namespace
+			k.notifySinks(e)
+		}
+		return
+	}
+	if ev.Key == "" || ev.Rev == 0 {
+		return
+	}
+	for _, s := range k.sinks {
+		if s.match != nil && !s.match(ev) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			sinkDropped.Add(1)
+			slog.Warn("sink falling behind, dropping event", "sink", s.name, "key", ev.Key, "rev", ev.Rev)
+			if s.drop != nil {
+				s.drop(ev)
+			}
+		}
+	}
+}
+
+// bridgeIdentity returns the identity writes arriving through the
+// integration name are made and authorized as: the write role, limited
+// by --acl name=... if given.
+func bridgeIdentity(name string) *Identity {
+	return &Identity{Subject: name, Method: name, Role: RoleWrite, Prefixes: aclRules[name]}
+}
+
+// removal reports whether ev removed its key.
+func removal(ev Event) bool {
+	return ev.Type == "delete" || ev.Type == "expire" || ev.Type == "evict"
+}
+
+// currentValue returns the value of the key of ev after the change, and
+// false if the change removed it. Set events carry the value; for the
+// list, set and hash operations, whose events only describe the delta,
+// it is read from the store.
+func (k *KVStore) currentValue(ev Event) ([]byte, bool) {
+	switch {
+	case removal(ev):
+		return nil, false
+	case ev.Type == "set" && ev.Encoding == "base64":
+		b, err := base64.StdEncoding.DecodeString(ev.Value)
+		return b, err == nil
+	case ev.Type == "set":
+		return []byte(ev.Value), true
+	}
+	e, ok := k.GetEntry(nsKey(ev.Namespace, ev.Key))
+	return []byte(e.Value), ok
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// truncater is implemented by backends whose log can be discarded once a
+// snapshot covers it.
+type truncater interface {
+	Truncate() error
+}
+
+// Snapshotter periodically writes the full store to timestamped files in
+// a directory and keeps only the most recent ones.
+type Snapshotter struct {
+	kv       *KVStore
+	dir      string
+	keep     int
+	interval time.Duration
+	every    uint64
+}
+
+// NewSnapshotter returns a Snapshotter that writes a snapshot of kv into
+// dir whenever interval has passed or every changes have been made since
+// the previous one, keeping the newest keep files. A zero interval or
+// every disables that trigger.
+func NewSnapshotter(kv *KVStore, dir string, interval time.Duration, every, keep int) (*Snapshotter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Snapshotter{kv: kv, dir: dir, keep: keep, interval: interval, every: uint64(every)}, nil
+}
+
+// Run checks the triggers once per second and never returns.
+func (s *Snapshotter) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	lastSeq := s.kv.Seq()
+	for now := range ticker.C {
+		seq := s.kv.Seq()
+		if seq == lastSeq {
+			continue
+		}
+		due := s.interval > 0 && now.Sub(last) >= s.interval
+		due = due || s.every > 0 && seq-lastSeq >= s.every
+		if !due {
+			continue
+		}
+		n, err := s.Snapshot()
+		if err != nil {
+			slog.Error("snapshot failed", "err", err)
+			continue
+		}
+		last, lastSeq = now, n
+	}
+}
+
+// Snapshot writes the current store to a new file, truncates the storage
+// log if the backend has one, and prunes old snapshots. It returns the
+// sequence number the snapshot covers.
+func (s *Snapshotter) Snapshot() (uint64, error) {
+	k := s.kv
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	seq := k.seq
+	b, err := json.Marshal(k.data)
+	if err != nil {
+		return 0, err
+	}
+	name := fmt.Sprintf("snapshot-%d.json", time.Now().UnixNano())
+	if err := writeFileSync(filepath.Join(s.dir, name), b); err != nil {
+		return 0, err
+	}
+	if t, ok := k.storage.(truncater); ok {
+		if err := t.Truncate(); err != nil {
+			return 0, fmt.Errorf("truncate log: %w", err)
+		}
+		// The snapshot only holds the revisions of the entries.
+		if rs, ok := k.storage.(RevisionStore); ok {
+			if err := rs.SetRevision(seq); err != nil {
+				return 0, fmt.Errorf("store revision: %w", err)
+			}
+		}
+	}
+	return seq, s.prune()
+}
+
+func (s *Snapshotter) prune() error {
+	names, err := snapshotFiles(s.dir)
+	if err != nil || len(names) <= s.keep {
+		return err
+	}
+	var errs []error
+	for _, name := range names[:len(names)-s.keep] {
+		errs = append(errs, os.Remove(filepath.Join(s.dir, name)))
+	}
+	return errors.Join(errs...)
+}
+
+// snapshotFiles returns the snapshot file names in dir, oldest first.
+func snapshotFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "snapshot-") && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// latestSnapshot returns the contents of the newest snapshot in dir, or
+// an empty map if there is none.
+func latestSnapshot(dir string) (map[string]Entry, error) {
+	data := make(map[string]Entry)
+	names, err := snapshotFiles(dir)
+	if errors.Is(err, os.ErrNotExist) || len(names) == 0 {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, names[len(names)-1])
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeFileSync writes b to path via a temporary file that is synced and
+// renamed into place.
+func writeFileSync(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS kv (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	entry TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS changes (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	time  TEXT NOT NULL,
+	op    TEXT NOT NULL,
+	key   TEXT NOT NULL,
+	value TEXT
+);
+CREATE INDEX IF NOT EXISTS changes_key ON changes (key, id);
+CREATE TABLE IF NOT EXISTS meta (
+	name  TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+`
+
+// SQLiteStorage keeps the current entries in a kv table and every
+// mutation in a changes table that can be queried with plain SQL.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage opens (or creates) the SQLite database at path.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+func (s *SQLiteStorage) Load() (map[string]Entry, error) {
+	rows, err := s.db.Query("SELECT key, entry FROM kv")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	data := make(map[string]Entry)
+	for rows.Next() {
+		var key, raw string
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			return nil, fmt.Errorf("decode %q: %w", key, err)
+		}
+		data[key] = e
+	}
+	return data, rows.Err()
+}
+
+func (s *SQLiteStorage) Save(data map[string]Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM kv"); err != nil {
+		return err
+	}
+	for k, e := range data {
+		if err := putEntry(tx, k, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) AppendChange(c Change) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var value any
+	switch c.Op {
+	case "set":
+		if err := putEntry(tx, c.Key, c.Entry); err != nil {
+			return err
+		}
+		value = c.Entry.Value
+	case "delete", "expire", "evict":
+		if _, err := tx.Exec("DELETE FROM kv WHERE key = ?", c.Key); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO changes (time, op, key, value) VALUES (?, ?, ?, ?)",
+		c.Time.UTC().Format(time.RFC3339Nano), c.Op, c.Key, value)
+	if err != nil {
+		return err
+	}
+	if err := setSQLiteRevision(tx, c.Rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) Revision() (uint64, error) {
+	var rev uint64
+	err := s.db.QueryRow("SELECT value FROM meta WHERE name = 'revision'").Scan(&rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return rev, err
+}
+
+func (s *SQLiteStorage) SetRevision(rev uint64) error {
+	return setSQLiteRevision(s.db, rev)
+}
+
+func setSQLiteRevision(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, rev uint64) error {
+	_, err := db.Exec(`INSERT INTO meta (name, value) VALUES ('revision', ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value`, rev)
+	return err
+}
+
+// Changes returns the most recent changes, newest first, optionally
+// restricted to a single key.
+func (s *SQLiteStorage) Changes(key string, limit int) ([]Change, error) {
+	rows, err := s.db.Query(`SELECT time, op, key, value FROM changes
+		WHERE ? = '' OR key = ? ORDER BY id DESC LIMIT ?`, key, key, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var c Change
+		var ts string
+		var value sql.NullString
+		if err := rows.Scan(&ts, &c.Op, &c.Key, &value); err != nil {
+			return nil, err
+		}
+		c.Time, _ = time.Parse(time.RFC3339Nano, ts)
+		c.Entry.Value = value.String
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// Ping checks that the database can be reached.
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func putEntry(tx *sql.Tx, key string, e Entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO kv (key, value, entry) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, entry = excluded.entry`,
+		key, e.Value, string(raw))
+	return err
+}
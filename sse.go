@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies do not time it out.
+const sseKeepAlive = 30 * time.Second
+
+var errStreamClosed = errors.New("stream closed")
+
+// clientConn is the connection of a subscriber: a *websocket.Conn or the
+// streamConn of an /events stream or gRPC Watch.
+type clientConn interface {
+	RemoteAddr() net.Addr
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	EnableWriteCompression(enable bool)
+	Close() error
+}
+
+// streamConn hands the messages of a subscriber to send, which writes
+// them to a response stream. Closing it ends the handler, after which
+// send is no longer called.
+type streamConn struct {
+	send   func(data []byte) error
+	remote net.Addr
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+func newStreamConn(remote net.Addr, send func([]byte) error) *streamConn {
+	return &streamConn{send: send, remote: remote, done: make(chan struct{})}
+}
+
+// httpAddr is the remote address of an HTTP request.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+func (s *streamConn) RemoteAddr() net.Addr { return s.remote }
+
+// WriteMessage sends data, an already encoded message.
+func (s *streamConn) WriteMessage(_ int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	return s.send(data)
+}
+
+// WriteControl closes the stream; streams have no close frames.
+func (s *streamConn) WriteControl(int, []byte, time.Time) error {
+	return s.Close()
+}
+
+func (s *streamConn) EnableWriteCompression(bool) {}
+
+func (s *streamConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// sseFrame frames data as a Server-Sent Event. A non-zero id is sent as
+// the event ID, which browsers return in Last-Event-ID on reconnect.
+func sseFrame(id uint64, data []byte) []byte {
+	var b []byte
+	if id > 0 {
+		b = append(b, "id: "...)
+		b = strconv.AppendUint(b, id, 10)
+		b = append(b, '\n')
+	}
+	b = append(b, "data: "...)
+	b = append(b, data...)
+	return append(b, "\n\n"...)
+}
+
+// eventID is the revision a client has seen once it received ev.
+func eventID(ev Event) uint64 {
+	id := ev.Rev
+	for _, e := range ev.Events {
+		id = max(id, e.Rev)
+	}
+	return id
+}
+
+// encodeSSE frames ev, as a plain JSON event, for an event stream.
+func encodeSSE(ev Event) []byte {
+	data, _ := json.Marshal(ev)
+	return sseFrame(eventID(ev), data)
+}
+
+// eventsHandler streams the events of a namespace as Server-Sent Events,
+// for clients where websockets are awkward. It takes the websocket query
+// parameters; a reconnecting client resumes from its Last-Event-ID.
+func (kv *KVStore) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	ns := r.PathValue("ns")
+	if ns == "" {
+		ns = r.URL.Query().Get("ns")
+	}
+	if strings.Contains(ns, nsSep) {
+		http.Error(w, "invalid namespace", 400)
+		return
+	}
+	if !kv.admitConn() {
+		wsRejected.Add(1)
+		slog.WarnContext(r.Context(), "websocket connection limit reached", "remote", r.RemoteAddr)
+		http.Error(w, "too many connections", 503)
+		return
+	}
+	defer kv.releaseConn()
+	rc := http.NewResponseController(w)
+	conn := newStreamConn(httpAddr(r.RemoteAddr), func(data []byte) error {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	client := &wsClient{conn: conn, ns: ns, id: identity(r.Context()), format: formatSSE}
+	q := r.URL.Query()
+	if err := kv.subscribeQuery(client, q); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	snapshot, _ := strconv.ParseBool(q.Get("snapshot"))
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		kv.addConnResume(client, id)
+	} else if seq, ok := lastSeq(q); ok {
+		kv.addConnResume(client, seq)
+	} else if snapshot {
+		kv.addConnSnapshot(client)
+	} else {
+		kv.addConn(client)
+	}
+	slog.InfoContext(r.Context(), "event stream connected", "remote", r.RemoteAddr, "namespace", ns, "subject", subject(r.Context()))
+	defer func() {
+		kv.removeConn(client)
+		// Wait for a write in progress; the response is gone after return.
+		conn.Close()
+		slog.InfoContext(r.Context(), "event stream disconnected", "remote", r.RemoteAddr, "namespace", ns)
+	}()
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-conn.done:
+			return
+		case <-ticker.C:
+			kv.connMu.Lock()
+			kv.queue(client, []byte(": keepalive\n\n"))
+			kv.connMu.Unlock()
+		}
+	}
+}
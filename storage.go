@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Change describes a single mutation of the store.
+type Change struct {
+	Op    string    `json:"op"`
+	Key   string    `json:"key"`
+	Entry Entry     `json:"entry,omitzero"`
+	Time  time.Time `json:"time"`
+
+	// Rev, Old and Origin are filled in by commit for the broadcast and
+	// are not persisted: the store revision of the change, the entry it
+	// replaced, if any, and who made it.
+	Rev    uint64 `json:"-"`
+	Old    *Entry `json:"-"`
+	Origin string `json:"-"`
+}
+
+// event returns the websocket notification for c.
+func (c Change) event() Event {
+	ev := Event{Type: c.Op, Key: c.Key, ContentType: c.Entry.ContentType, ValueType: c.Entry.Type}
+	ev.Value, ev.Encoding = c.Entry.jsonValue()
+	return c.stamp(ev)
+}
+
+// stamp copies the revision, time, origin and previous value of c to
+// ev, for events that describe c in their own way.
+func (c Change) stamp(ev Event) Event {
+	ev.Rev, ev.Time, ev.Origin = c.Rev, c.Time, c.Origin
+	if c.Old != nil {
+		old, _ := c.Old.jsonValue()
+		ev.Old = &old
+	}
+	return ev
+}
+
+// Storage persists the contents of a KVStore between restarts.
+type Storage interface {
+	// Load returns the persisted entries.
+	Load() (map[string]Entry, error)
+	// Save replaces the persisted state with data.
+	Save(data map[string]Entry) error
+	// AppendChange records a single mutation.
+	AppendChange(c Change) error
+}
+
+// ChangeLog is implemented by backends that keep a queryable history of
+// past changes.
+type ChangeLog interface {
+	// Changes returns up to limit changes, newest first. An empty key
+	// returns changes for all keys.
+	Changes(key string, limit int) ([]Change, error)
+}
+
+// ChangeFeed is implemented by backends shared between several servers.
+// Watch starts delivering changes made by other instances to fn, and
+// Publish announces to them changes persisted with Save rather than
+// AppendChange.
+type ChangeFeed interface {
+	Watch(fn func(Change)) error
+	Publish(cs []Change) error
+}
+
+// RevisionStore is implemented by backends that persist the store
+// revision along with the entries. The entries alone only give the
+// revision of the latest set, which is too low if later changes removed
+// keys. AppendChange keeps it at the revision of the change; SetRevision
+// stores rev after a Save.
+type RevisionStore interface {
+	// Revision returns the revision persisted with the entries returned
+	// by Load, or zero if there is none.
+	Revision() (uint64, error)
+	SetRevision(rev uint64) error
+}
+
+// storageConfig selects and configures a storage backend.
+type storageConfig struct {
+	Kind        string
+	DataFile    string
+	DBPath      string
+	RedisURL    string
+	RedisPrefix string
+	WALPath     string
+	WALFsync    string
+	SnapshotDir string
+}
+
+// openStorage returns the backend selected by cfg.Kind.
+func openStorage(cfg storageConfig) (Storage, error) {
+	switch cfg.Kind {
+	case "file":
+		if cfg.DataFile == "" {
+			return nil, nil
+		}
+		return NewFileStorage(cfg.DataFile), nil
+	case "bolt":
+		return NewBoltStorage(cfg.DBPath)
+	case "sqlite":
+		return NewSQLiteStorage(cfg.DBPath)
+	case "redis":
+		return NewRedisStorage(cfg.RedisURL, cfg.RedisPrefix)
+	case "wal":
+		return NewWALStorage(cfg.WALPath, cfg.WALFsync, cfg.SnapshotDir)
+	case "memory":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Kind)
+}
+
+// applyChange applies c to data in place.
+func applyChange(data map[string]Entry, c Change) {
+	switch c.Op {
+	case "set":
+		data[c.Key] = c.Entry
+	case "delete", "expire", "evict":
+		delete(data, c.Key)
+	}
+}
+
+// FileStorage keeps the store as a JSON snapshot on disk and rewrites
+// the file after every change. The store revision is kept as the Rev of
+// an entry under the empty key, which is never a valid key.
+type FileStorage struct {
+	path string
+	mu   sync.Mutex
+	data map[string]Entry
+	rev  uint64
+}
+
+// NewFileStorage returns a FileStorage backed by the file at path.
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{path: path, data: make(map[string]Entry)}
+}
+
+func (s *FileStorage) Load() (map[string]Entry, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]Entry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]Entry)
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	s.rev = data[""].Rev
+	delete(data, "")
+	s.data = make(map[string]Entry, len(data))
+	for k, e := range data {
+		s.data[k] = e
+	}
+	s.mu.Unlock()
+	return data, nil
+}
+
+func (s *FileStorage) Save(data map[string]Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]Entry, len(data))
+	for k, e := range data {
+		s.data[k] = e
+	}
+	return s.write()
+}
+
+func (s *FileStorage) AppendChange(c Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	applyChange(s.data, c)
+	s.rev = c.Rev
+	return s.write()
+}
+
+func (s *FileStorage) Revision() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rev, nil
+}
+
+func (s *FileStorage) SetRevision(rev uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rev = rev
+	return s.write()
+}
+
+// write replaces the snapshot file atomically. Callers must hold s.mu.
+func (s *FileStorage) write() error {
+	s.data[""] = Entry{Rev: s.rev}
+	b, err := json.Marshal(s.data)
+	delete(s.data, "")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// storageBackends open each persistent backend on files in dir.
+var storageBackends = map[string]func(t *testing.T, dir string) Storage{
+	"file": func(t *testing.T, dir string) Storage {
+		return NewFileStorage(filepath.Join(dir, "data.json"))
+	},
+	"bolt": func(t *testing.T, dir string) Storage {
+		s, err := NewBoltStorage(filepath.Join(dir, "data.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"sqlite": func(t *testing.T, dir string) Storage {
+		s, err := NewSQLiteStorage(filepath.Join(dir, "data.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"wal": func(t *testing.T, dir string) Storage {
+		s, err := NewWALStorage(filepath.Join(dir, "data.wal"), "never", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+// reopen closes st, if it needs closing, and opens a store on the
+// backend again.
+func reopen(t *testing.T, st Storage, open func(t *testing.T, dir string) Storage, dir string) *KVStore {
+	t.Helper()
+	if c, ok := st.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st = open(t, dir)
+	if c, ok := st.(io.Closer); ok {
+		t.Cleanup(func() { c.Close() })
+	}
+	k, err := NewKVStore(st, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestStoragePersists(t *testing.T) {
+	for name, open := range storageBackends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			st := open(t, dir)
+			k, err := NewKVStore(st, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			k.Set(ctx, "a", "1")
+			k.Put(ctx, nsKey("ns", "b"), Entry{Value: "2", ContentType: "text/plain"})
+			k.Set(ctx, "c", "3")
+			k.Delete(ctx, "c")
+
+			k = reopen(t, st, open, dir)
+			if v, _ := k.Get("a"); v != "1" {
+				t.Errorf("a = %q, want 1", v)
+			}
+			if e, _ := k.GetEntry(nsKey("ns", "b")); e.Value != "2" || e.ContentType != "text/plain" || e.Rev != 2 {
+				t.Errorf("ns/b = %+v, want value 2 of text/plain at revision 2", e)
+			}
+			if _, ok := k.Get("c"); ok {
+				t.Error("deleted key came back")
+			}
+			if k.Seq() != 4 {
+				t.Errorf("seq after restart = %d, want 4: the revision of the delete", k.Seq())
+			}
+		})
+	}
+}
+
+func TestStorageRevisionAfterRestore(t *testing.T) {
+	for name, open := range storageBackends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			st := open(t, dir)
+			k, err := NewKVStore(st, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			k.Set(ctx, "a", "1")
+			k.Set(ctx, "b", "2")
+			if _, err := k.Restore(ctx, map[string]Entry{"a": {Value: "1", Rev: 1}}, false); err != nil {
+				t.Fatal(err)
+			}
+
+			k = reopen(t, st, open, dir)
+			if _, ok := k.Get("b"); ok {
+				t.Error("key deleted by the restore came back")
+			}
+			if k.Seq() != 3 {
+				t.Errorf("seq after restart = %d, want 3", k.Seq())
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errValueTooLarge is returned when a value exceeds the configured
+// maximum size.
+var errValueTooLarge = errors.New("value too large")
+
+// readValue streams a raw request body of at most max bytes into a
+// string; a max of zero or less means no limit. The buffer is sized up
+// front when the client sends a Content-Length, so large uploads are
+// copied only once.
+func readValue(r *http.Request, max int64) (string, error) {
+	if max <= 0 {
+		max = math.MaxInt64 - 1
+	}
+	if r.ContentLength > max {
+		return "", errValueTooLarge
+	}
+	var sb strings.Builder
+	if r.ContentLength > 0 {
+		sb.Grow(int(r.ContentLength))
+	}
+	n, err := io.Copy(&sb, io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return "", err
+	}
+	if n > max {
+		return "", errValueTooLarge
+	}
+	return sb.String(), nil
+}
+
+// serveValue streams e as the response body. Range and HEAD requests are
+// handled so clients can fetch large values in pieces.
+func serveValue(w http.ResponseWriter, r *http.Request, e Entry) {
+	w.Header().Set("Content-Type", e.contentType())
+	w.Header().Set("ETag", etag(e.Rev))
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(e.Value))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// keySubs are the keys and key prefixes a websocket client subscribed
+// to. A client without subscriptions receives every change of its
+// namespace.
+type keySubs struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+// match reports whether key is subscribed.
+func (s *keySubs) match(key string) bool {
+	if s.keys[key] {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *keySubs) empty() bool {
+	return len(s.keys) == 0 && len(s.prefixes) == 0
+}
+
+// subscribeKeys adds the key or prefix to, or removes it from, the
+// subscriptions of client c. Keys are relative to the namespace of the
+// connection.
+func (k *KVStore) subscribeKeys(c *wsClient, key, prefix string, on bool) error {
+	if (key == "") == (prefix == "") {
+		return errors.New("need one of topic, key or prefix")
+	}
+	if (key != "" && !validName(key)) || strings.Contains(prefix, nsSep) {
+		return errors.New("invalid key")
+	}
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	if c.subs == nil {
+		c.subs = &keySubs{keys: make(map[string]bool)}
+	}
+	switch {
+	case key != "" && on:
+		c.subs.keys[key] = true
+	case key != "":
+		delete(c.subs.keys, key)
+	case on:
+		c.subs.prefixes = append(c.subs.prefixes, prefix)
+	default:
+		for i, p := range c.subs.prefixes {
+			if p == prefix {
+				c.subs.prefixes = append(c.subs.prefixes[:i], c.subs.prefixes[i+1:]...)
+				break
+			}
+		}
+	}
+	if c.subs.empty() {
+		c.subs = nil
+	}
+	return nil
+}
+
+// subscribeQuery applies the topic, key and prefix query parameters of a
+// websocket upgrade.
+func (k *KVStore) subscribeQuery(c *wsClient, q url.Values) error {
+	for _, topic := range q["topic"] {
+		if err := k.subscribeTopic(c, topic, true); err != nil {
+			return err
+		}
+	}
+	for _, key := range q["key"] {
+		if err := k.subscribeKeys(c, key, "", true); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range q["prefix"] {
+		if err := k.subscribeKeys(c, "", prefix, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filters reports whether events need to be filtered for client c,
+// either by the prefixes of its identity or by its subscriptions.
+// Callers must hold k.connMu.
+func (c *wsClient) filters() bool {
+	return (c.id != nil && c.id.Prefixes != nil) || c.subs != nil
+}
+
+// filter returns ev as seen by client c, trimming batches to the events
+// it may and wants to see, and reports whether anything is left.
+// Callers must hold k.connMu.
+func (c *wsClient) filter(ev Event) (Event, bool) {
+	ev, ok := c.id.visible(ev)
+	if !ok || c.subs == nil {
+		return ev, ok
+	}
+	return filterEvent(ev, c.subs.match)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracer creates the spans for storage writes and broadcasts. Until
+// setupTracing installs an exporter its spans are no-ops.
+var tracer = otel.Tracer("github.com/matst80/go-info-share")
+
+// tracingConfig selects the OTLP exporter. An empty Endpoint disables
+// tracing.
+type tracingConfig struct {
+	// Endpoint is the collector URL, e.g. http://otel-collector:4318 for
+	// OTLP over HTTP, where /v1/traces is added if no path is given, or
+	// http://otel-collector:4317 for gRPC. An http:// scheme disables TLS.
+	Endpoint    string
+	Protocol    string
+	ServiceName string
+	SampleRatio float64
+}
+
+// setupTracing installs a global tracer provider that exports spans to
+// cfg.Endpoint and a W3C trace context propagator. The returned function
+// flushes pending spans on shutdown.
+func setupTracing(ctx context.Context, cfg tracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	var exp sdktrace.SpanExporter
+	var err error
+	switch cfg.Protocol {
+	case "http":
+		endpoint := cfg.Endpoint
+		if u, perr := url.Parse(endpoint); perr == nil && strings.Trim(u.Path, "/") == "" {
+			endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+		}
+		exp, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	case "grpc":
+		exp, err = otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("service.version", version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// traced wraps h with server spans named after the matched route, e.g.
+// "PUT /kv/{key...}", continuing any trace context sent by the client.
+func traced(mux *http.ServeMux, h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		return r.Method + " " + pattern
+	}))
+}
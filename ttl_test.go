@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpiry(t *testing.T) {
+	k := newTestStore(t)
+	events := connect(t, k)
+	ctx := context.Background()
+	k.Put(ctx, "a", newEntry("1", 20*time.Millisecond))
+	k.Set(ctx, "b", "2")
+	if _, ok := k.Get("a"); !ok {
+		t.Fatal("key expired before its ttl")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := k.Get("a"); ok {
+		t.Error("expired key is still readable")
+	}
+	go k.expireLoop(5 * time.Millisecond)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != "expire" {
+				continue
+			}
+			if ev.Key != "a" {
+				t.Errorf("expire event for %q, want a", ev.Key)
+			}
+			if _, ok := k.Get("b"); !ok {
+				t.Error("key without a ttl expired")
+			}
+			return
+		case <-timeout:
+			t.Fatal("no expire event")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// errWrongType is returned when a value does not parse as the type
+// declared for it.
+var errWrongType = errors.New("value does not match declared type")
+
+// validType reports whether t is a value type clients may declare. The
+// empty type stores untyped text as before.
+func validType(t string) bool {
+	switch t {
+	case "", "string", "int", "float", "bool", "json", "list", "set", "hash":
+		return true
+	}
+	return false
+}
+
+// normalizeTyped parses value as type t and returns its canonical text
+// form, so that "1.50" and "1.5" are stored the same way for a float.
+func normalizeTyped(t, value string) (string, error) {
+	s := strings.TrimSpace(value)
+	switch t {
+	case "int":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: want int", errWrongType)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "float":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return "", fmt.Errorf("%w: want float", errWrongType)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return "", fmt.Errorf("%w: want bool", errWrongType)
+		}
+		return strconv.FormatBool(b), nil
+	case "json":
+		if !json.Valid([]byte(value)) {
+			return "", fmt.Errorf("%w: want json", errWrongType)
+		}
+	case "list":
+		var items []string
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return "", fmt.Errorf("%w: want a JSON array of strings", errWrongType)
+		}
+		return withList(Entry{}, items).Value, nil
+	case "set":
+		var items []string
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return "", fmt.Errorf("%w: want a JSON array of strings", errWrongType)
+		}
+		set := make(map[string]bool, len(items))
+		for _, m := range items {
+			set[m] = true
+		}
+		return withSet(Entry{}, set).Value, nil
+	case "hash":
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(value), &fields); err != nil || fields == nil {
+			return "", fmt.Errorf("%w: want a JSON object of strings", errWrongType)
+		}
+		return withHash(Entry{}, fields).Value, nil
+	}
+	return value, nil
+}
+
+// checkType reports whether e still holds a value of its declared type,
+// e.g. after an append to an int.
+func checkType(e Entry) error {
+	if e.Type == "" {
+		return nil
+	}
+	_, err := normalizeTyped(e.Type, e.Value)
+	return err
+}
+
+// typedJSON returns the value of a typed entry as a JSON document: a
+// quoted string for strings and the canonical text otherwise.
+func (e Entry) typedJSON() json.RawMessage {
+	if e.Type == "string" {
+		b, _ := json.Marshal(e.Value)
+		return b
+	}
+	return json.RawMessage(e.Value)
+}
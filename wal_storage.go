@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// WALStorage appends every change to a log file as one JSON line and
+// rebuilds the store by replaying the log on startup. The store revision
+// is the highest one of the records, which SetRevision appends a
+// "revision" record for.
+type WALStorage struct {
+	path  string
+	fsync string
+	base  string
+	mu    sync.Mutex
+	f     *os.File
+	dirty bool
+	rev   uint64
+	done  chan struct{}
+}
+
+// NewWALStorage opens the log at path. fsync selects when writes are
+// flushed to disk: "always" after every change, "interval" once per
+// second, or "never" to leave it to the operating system. If snapshotDir
+// is set, Load starts from the newest snapshot found there and replays
+// the log on top of it.
+func NewWALStorage(path, fsync, snapshotDir string) (*WALStorage, error) {
+	switch fsync {
+	case "always", "interval", "never":
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", fsync)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	s := &WALStorage{path: path, fsync: fsync, base: snapshotDir, f: f, done: make(chan struct{})}
+	if fsync == "interval" {
+		go s.syncLoop(time.Second)
+	}
+	return s, nil
+}
+
+func (s *WALStorage) Load() (map[string]Entry, error) {
+	data := make(map[string]Entry)
+	if s.base != "" {
+		var err error
+		if data, err = latestSnapshot(s.base); err != nil {
+			return nil, err
+		}
+	}
+	return data, s.Replay(data)
+}
+
+// Replay applies every change in the log to data. A truncated final
+// record left by a crash is ignored.
+func (s *WALStorage) Replay(data map[string]Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Seek(0, 0); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(s.f)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		var rec walRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			slog.Warn("wal: skipping corrupt record", "record", n, "path", s.path, "err", err)
+			continue
+		}
+		applyChange(data, rec.Change)
+		s.rev = max(s.rev, rec.Rev)
+	}
+	return sc.Err()
+}
+
+func (s *WALStorage) Revision() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rev, nil
+}
+
+func (s *WALStorage) SetRevision(rev uint64) error {
+	return s.AppendChange(Change{Op: "revision", Rev: rev, Time: time.Now()})
+}
+
+// Save rewrites the log so it contains exactly one record per key. With
+// a snapshot directory, keys of the newest snapshot that are not in data
+// get delete records, so Load does not bring them back.
+func (s *WALStorage) Save(data map[string]Entry) error {
+	var stale []string
+	if s.base != "" {
+		snap, err := latestSnapshot(s.base)
+		if err != nil {
+			return err
+		}
+		for k := range snap {
+			if _, ok := data[k]; !ok {
+				stale = append(stale, k)
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	now := time.Now()
+	for _, k := range stale {
+		if err := writeRecord(w, Change{Op: "delete", Key: k, Time: now}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	for k, e := range data {
+		if err := writeRecord(w, Change{Op: "set", Key: k, Entry: e, Time: now}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.reopen(tmp)
+}
+
+// Truncate empties the log, typically after its contents have been
+// captured in a snapshot.
+func (s *WALStorage) Truncate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	s.dirty = false
+	return s.f.Sync()
+}
+
+func (s *WALStorage) AppendChange(c Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeRecord(s.f, c); err != nil {
+		return err
+	}
+	s.rev = max(s.rev, c.Rev)
+	if s.fsync == "always" {
+		return s.f.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+// Close flushes and closes the log file.
+func (s *WALStorage) Close() error {
+	close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.f.Sync(), s.f.Close())
+}
+
+// reopen replaces the log with the file at tmp. Callers must hold s.mu.
+func (s *WALStorage) reopen(tmp string) error {
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	s.dirty = false
+	return nil
+}
+
+func (s *WALStorage) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.f.Sync(); err != nil {
+					slog.Error("wal: sync failed", "err", err)
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// walRecord is a line of the log: a change along with its revision,
+// which Change leaves out.
+type walRecord struct {
+	Change
+	Rev uint64 `json:"rev,omitempty"`
+}
+
+func writeRecord(w io.Writer, c Change) error {
+	b, err := json.Marshal(walRecord{Change: c, Rev: c.Rev})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultWatchWait is how long /watch waits without a ?timeout=.
+	defaultWatchWait = 30 * time.Second
+	// maxWatchWait caps the ?timeout= of /watch.
+	maxWatchWait = 5 * time.Minute
+)
+
+// watcher is a /watch request waiting for a change. Its client is never
+// added to the broadcast list; it only carries the namespace, identity
+// and key subscriptions used to match events.
+type watcher struct {
+	c  *wsClient
+	ch chan Event
+}
+
+// watchResult is the response of /watch.
+type watchResult struct {
+	Revision uint64  `json:"revision"`
+	Events   []Event `json:"events"`
+}
+
+// notifyWatchers hands ev to the watchers it concerns and drops them.
+// Callers must hold k.connMu.
+func (k *KVStore) notifyWatchers(ev Event) {
+	for w := range k.watchers {
+		if !w.c.wants(ev) {
+			continue
+		}
+		if ev, ok := w.c.filter(ev); ok {
+			w.ch <- ev
+			delete(k.watchers, w)
+		}
+	}
+}
+
+// watchHandler serves /watch?key=&since=, and ?prefix= for any key under
+// a prefix. It returns the changes after revision since, waiting for the
+// next one for up to ?timeout= if there are none yet; without since it
+// waits for the next change. If since is older than the replay buffer
+// the client gets 410 and should read the key and watch from there.
+// Like the other change streams, it needs credentials once
+// authentication is configured.
+func (kv *KVStore) watchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ns := q.Get("ns")
+	if strings.Contains(ns, nsSep) {
+		http.Error(w, "invalid namespace", 400)
+		return
+	}
+	if len(q["key"]) == 0 && len(q["prefix"]) == 0 {
+		http.Error(w, "missing key or prefix", 400)
+		return
+	}
+	c := &wsClient{ns: ns, id: identity(r.Context())}
+	for _, key := range q["key"] {
+		if err := kv.subscribeKeys(c, key, "", true); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	for _, prefix := range q["prefix"] {
+		if err := kv.subscribeKeys(c, "", prefix, true); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	cur := kv.Seq()
+	since := cur
+	if s := q.Get("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", 400)
+			return
+		}
+		since = n
+	}
+	wait := defaultWatchWait
+	if s := q.Get("timeout"); s != "" {
+		d, err := parseTTL(s)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid timeout", 400)
+			return
+		}
+		wait = min(d, maxWatchWait)
+	}
+
+	kv.connMu.Lock()
+	missed, ok := kv.replay.since(since)
+	if !ok || since > cur {
+		kv.connMu.Unlock()
+		http.Error(w, "revision no longer available, read the current value and watch from its revision", 410)
+		return
+	}
+	var events []Event
+	for _, ev := range missed {
+		if !c.wants(ev) {
+			continue
+		}
+		if ev, ok := c.filter(ev); ok {
+			events = append(events, ev)
+		}
+	}
+	if len(events) > 0 {
+		kv.connMu.Unlock()
+		writeWatch(w, events)
+		return
+	}
+	wt := &watcher{c: c, ch: make(chan Event, 1)}
+	if kv.watchers == nil {
+		kv.watchers = make(map[*watcher]bool)
+	}
+	kv.watchers[wt] = true
+	kv.connMu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case ev := <-wt.ch:
+		if ev.Type == "batch" {
+			writeWatch(w, ev.Events)
+		} else {
+			writeWatch(w, []Event{ev})
+		}
+		return
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+	kv.connMu.Lock()
+	delete(kv.watchers, wt)
+	kv.connMu.Unlock()
+}
+
+func writeWatch(w http.ResponseWriter, events []Event) {
+	res := watchResult{Events: events}
+	for _, ev := range events {
+		res.Revision = max(res.Revision, ev.Rev)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
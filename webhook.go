@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhookTimeout bounds a single webhook delivery attempt.
+const webhookTimeout = 10 * time.Second
+
+// Retries of a failed delivery wait between webhookMinBackoff and
+// webhookMaxBackoff.
+const (
+	webhookMinBackoff = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+)
+
+// webhookDeadLetters is the number of failed deliveries kept per
+// webhook.
+const webhookDeadLetters = 1000
+
+// Webhook is an endpoint that receives a POST of the versioned envelope
+// of every change to the keys under Prefixes, matched like --acl
+// prefixes; nil Prefixes match every key.
+type Webhook struct {
+	URL      string
+	Prefixes []string
+}
+
+// parseWebhookFlag parses a --webhook value of the form url [prefix...].
+func parseWebhookFlag(s string) (Webhook, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Webhook{}, fmt.Errorf("webhook %q has no url", s)
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("webhook url %q is not an http(s) URL", fields[0])
+	}
+	h := Webhook{URL: fields[0]}
+	if len(fields) > 1 {
+		h.Prefixes = normalizePrefixes(fields[1:])
+	}
+	return h, nil
+}
+
+// wants reports whether h receives the changes of key in namespace ns.
+func (h Webhook) wants(ns, key string) bool {
+	if h.Prefixes == nil {
+		return true
+	}
+	name := aclKey(ns, key)
+	for _, p := range h.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhook returns the X-Info-Share-Signature-256 header for body:
+// "sha256=" and the hex HMAC-SHA256 of body keyed with secret.
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEndpoint delivers the changes matching a Webhook from a queue
+// of its own, retrying failed deliveries with exponential backoff and
+// keeping those that fail for good in a dead-letter list.
+type webhookEndpoint struct {
+	Webhook
+	client      *http.Client
+	secret      []byte
+	maxAttempts int
+	sink        *sink
+
+	mu          sync.Mutex
+	delivered   uint64
+	failures    uint64
+	retrying    bool
+	lastError   string
+	lastAttempt time.Time
+	lastSuccess time.Time
+	dead        []deadLetter
+}
+
+// deadLetter is a change that could not be delivered to a webhook.
+type deadLetter struct {
+	ID       uint64    `json:"id"`
+	URL      string    `json:"url"`
+	Event    envelope  `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+	ev       Event
+}
+
+// webhookStatus describes the deliveries to a webhook for
+// /admin/webhooks. Pending counts the queued changes; Retrying is set
+// while the oldest of them is waiting for another attempt.
+type webhookStatus struct {
+	URL         string    `json:"url"`
+	Prefixes    []string  `json:"prefixes,omitempty"`
+	Pending     int       `json:"pending"`
+	Retrying    bool      `json:"retrying"`
+	Delivered   uint64    `json:"delivered"`
+	Failures    uint64    `json:"failures"`
+	DeadLetters int       `json:"dead_letters"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+}
+
+// lastDeadLetter numbers the dead letters of all webhooks.
+var lastDeadLetter atomic.Uint64
+
+// StartWebhooks delivers the changes of the store to hooks, each from a
+// queue of its own so a slow endpoint does not delay the others.
+// Deliveries are signed with secret unless it is empty; receivers
+// verify X-Info-Share-Signature-256 against the raw request body. A
+// delivery is attempted up to maxAttempts times before it is moved to
+// the dead-letter list, as are changes that arrive while the queue is
+// full.
+func (k *KVStore) StartWebhooks(hooks []Webhook, secret string, maxAttempts int) {
+	client := &http.Client{Timeout: webhookTimeout}
+	for _, h := range hooks {
+		ep := &webhookEndpoint{Webhook: h, client: client, secret: []byte(secret), maxAttempts: max(maxAttempts, 1)}
+		ep.sink = &sink{
+			name:    "webhook " + h.URL,
+			deliver: ep.deliver,
+			match:   func(ev Event) bool { return h.wants(ev.Namespace, ev.Key) },
+			drop:    func(ev Event) { ep.deadLetter(ev, 0, "queue full") },
+		}
+		k.addSink(ep.sink)
+		k.webhooks = append(k.webhooks, ep)
+	}
+}
+
+// deliver posts the envelope of ev until it is accepted, the error is
+// not worth retrying or maxAttempts is reached.
+func (ep *webhookEndpoint) deliver(ev Event) {
+	body, err := json.Marshal(newEnvelope(ev))
+	if err != nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		wait, err := ep.post(ev, body, attempt)
+		ep.mu.Lock()
+		ep.lastAttempt = time.Now()
+		if err == nil {
+			ep.delivered++
+			ep.lastSuccess, ep.retrying = ep.lastAttempt, false
+		} else {
+			ep.failures++
+			ep.lastError = err.Error()
+			ep.retrying = wait > 0 && attempt < ep.maxAttempts
+		}
+		retrying := ep.retrying
+		ep.mu.Unlock()
+		if err == nil {
+			return
+		}
+		if !retrying {
+			slog.Warn("webhook delivery failed", "url", ep.URL, "key", ev.Key, "rev", ev.Rev, "attempts", attempt, "err", err)
+			ep.deadLetter(ev, attempt, err.Error())
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// post makes one delivery attempt of body. On failure it returns how
+// long to wait before the next attempt, or 0 if the error is permanent:
+// client errors other than 408 and 429.
+func (ep *webhookEndpoint) post(ev Event, body []byte, attempt int) (time.Duration, error) {
+	req, err := http.NewRequest("POST", ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "info-share/"+version)
+	req.Header.Set("X-Info-Share-Event", ev.Type)
+	req.Header.Set("X-Info-Share-Delivery", strconv.FormatUint(ev.Rev, 10))
+	req.Header.Set("X-Info-Share-Attempt", strconv.Itoa(attempt))
+	if len(ep.secret) > 0 {
+		req.Header.Set("X-Info-Share-Signature-256", signWebhook(ep.secret, body))
+	}
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return webhookBackoff(attempt), err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case code/100 == 2:
+		return 0, nil
+	case code == 408 || code == 429 || code >= 500:
+		wait := webhookBackoff(attempt)
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = min(time.Duration(s)*time.Second, webhookMaxBackoff)
+		}
+		return wait, fmt.Errorf("status %d", code)
+	default:
+		return 0, fmt.Errorf("status %d", code)
+	}
+}
+
+// webhookBackoff returns the wait after the given failed attempt: it
+// doubles from webhookMinBackoff up to webhookMaxBackoff, with jitter so
+// retries of many changes do not arrive together.
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookMaxBackoff
+	if attempt < 20 {
+		d = min(webhookMinBackoff<<(attempt-1), webhookMaxBackoff)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// deadLetter adds ev to the dead-letter list, dropping the oldest entry
+// once webhookDeadLetters are kept.
+func (ep *webhookEndpoint) deadLetter(ev Event, attempts int, reason string) {
+	d := deadLetter{
+		ID:       lastDeadLetter.Add(1),
+		URL:      ep.URL,
+		Event:    newEnvelope(ev),
+		Attempts: attempts,
+		Error:    reason,
+		Time:     time.Now(),
+		ev:       ev,
+	}
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if len(ep.dead) >= webhookDeadLetters {
+		ep.dead = ep.dead[1:]
+	}
+	ep.dead = append(ep.dead, d)
+}
+
+func (ep *webhookEndpoint) status() webhookStatus {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return webhookStatus{
+		URL:         ep.URL,
+		Prefixes:    ep.Prefixes,
+		Pending:     len(ep.sink.events),
+		Retrying:    ep.retrying,
+		Delivered:   ep.delivered,
+		Failures:    ep.failures,
+		DeadLetters: len(ep.dead),
+		LastError:   ep.lastError,
+		LastAttempt: ep.lastAttempt,
+		LastSuccess: ep.lastSuccess,
+	}
+}
+
+// takeDeadLetters removes and returns the dead letters selected by id,
+// or all of them if id is 0.
+func (ep *webhookEndpoint) takeDeadLetters(id uint64) []deadLetter {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	var taken []deadLetter
+	kept := ep.dead[:0]
+	for _, d := range ep.dead {
+		if id == 0 || d.ID == id {
+			taken = append(taken, d)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	ep.dead = kept
+	return taken
+}
+
+// retry queues dead letters for delivery again, putting back those the
+// full queue cannot take. It returns the number queued.
+func (ep *webhookEndpoint) retry(dead []deadLetter) int {
+	n := 0
+	for i, d := range dead {
+		select {
+		case ep.sink.events <- d.ev:
+			n++
+		default:
+			ep.mu.Lock()
+			ep.dead = append(dead[i:], ep.dead...)
+			ep.mu.Unlock()
+			return n
+		}
+	}
+	return n
+}
+
+// webhooksHandler serves the delivery status of the webhooks:
+//
+//	GET    /admin/webhooks                     status of every webhook
+//	GET    /admin/webhooks/dead-letters        failed deliveries, ?url= for one webhook
+//	POST   /admin/webhooks/dead-letters/retry  queue them again
+//	DELETE /admin/webhooks/dead-letters        discard them
+//
+// Retry and discard take ?id= for a single dead letter, or apply to all
+// of them, or to those of ?url=.
+func (kv *KVStore) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var eps []*webhookEndpoint
+	for _, ep := range kv.webhooks {
+		if u := q.Get("url"); u == "" || u == ep.URL {
+			eps = append(eps, ep)
+		}
+	}
+	var id uint64
+	if q.Has("id") {
+		var err error
+		if id, err = strconv.ParseUint(q.Get("id"), 10, 64); err != nil || id == 0 {
+			http.Error(w, "invalid id", 400)
+			return
+		}
+	}
+	route := r.Method + " " + r.URL.Path
+	switch route {
+	case "GET /admin/webhooks", "HEAD /admin/webhooks":
+		out := make([]webhookStatus, 0, len(eps))
+		for _, ep := range eps {
+			out = append(out, ep.status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case "GET /admin/webhooks/dead-letters", "HEAD /admin/webhooks/dead-letters":
+		out := make([]deadLetter, 0)
+		for _, ep := range eps {
+			ep.mu.Lock()
+			for _, d := range ep.dead {
+				if id == 0 || d.ID == id {
+					out = append(out, d)
+				}
+			}
+			ep.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case "POST /admin/webhooks/dead-letters/retry", "DELETE /admin/webhooks/dead-letters":
+		n := 0
+		for _, ep := range eps {
+			dead := ep.takeDeadLetters(id)
+			if r.Method == "POST" {
+				n += ep.retry(dead)
+			} else {
+				n += len(dead)
+			}
+		}
+		slog.InfoContext(r.Context(), "webhook dead letters handled by administrator", "action", r.Method, "count", n, "subject", subject(r.Context()))
+		fmt.Fprint(w, n)
+	default:
+		if r.URL.Path == "/admin/webhooks/dead-letters/retry" {
+			w.Header().Set("Allow", "POST, OPTIONS")
+		} else if r.URL.Path == "/admin/webhooks/dead-letters" {
+			w.Header().Set("Allow", "GET, HEAD, DELETE, OPTIONS")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		}
+		http.Error(w, "method not allowed", 405)
+	}
+}
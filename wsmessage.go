@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// wsMessage is a request sent by a websocket client. Op selects the
+// operation; Type is accepted as an alias. Keys are relative to the
+// namespace of the connection, and ID, if set, is echoed in the reply so
+// clients can match replies to pipelined requests.
+type wsMessage struct {
+	Op          string `json:"op"`
+	Type        string `json:"type"`
+	ID          string `json:"id,omitempty"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ContentType string `json:"content_type,omitempty"`
+	ValueType   string `json:"value_type,omitempty"`
+	TTL         string `json:"ttl,omitempty"`
+	By          *int64 `json:"by,omitempty"`
+	// Wait, Visibility and Delivery go with the queue ops, for which Key
+	// names the queue.
+	Wait       string `json:"wait,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+	Delivery   string `json:"delivery,omitempty"`
+	// Topic or Prefix, or Key, go with subscribe and unsubscribe.
+	Topic  string `json:"topic,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// wsReply answers a wsMessage with an "ack", carrying the revision or the
+// resulting value of the operation, or an "error".
+type wsReply struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"`
+	Op       string `json:"op,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Rev      uint64 `json:"rev,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Delivery string `json:"delivery,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleMessage runs a message read from client c and replies to it:
+//
+//	{"op":"set","key":"k","value":"v"}        set a key, with optional ttl, content_type
+//	                                         and value_type; the ack carries the revision
+//	{"op":"delete","key":"k"}                delete a key
+//	{"op":"get","key":"k"}                   read a key; the ack carries value and revision
+//	{"op":"ephemeral","key":"k","value":"v"}  set a key bound to the connection
+//	{"op":"incr","key":"k","by":5}           add to a counter, by defaults to 1
+//	{"op":"decr","key":"k"}                  subtract from a counter
+//	{"op":"enqueue","key":"q","value":"v"}   add an item to a queue
+//	{"op":"dequeue","key":"q","wait":"30s"}  take an item; the ack carries no
+//	                                         delivery if the queue stayed empty
+//	{"op":"ack","key":"q","delivery":"id"}   acknowledge a delivered item
+//	{"op":"subscribe","topic":"t"}           receive messages published to a topic
+//	{"op":"subscribe","prefix":"app/"}       only receive changes of these keys;
+//	{"op":"subscribe","key":"k"}             without subscriptions all keys are sent
+//	{"op":"unsubscribe",...}                 drop a topic, prefix or key subscription
+//
+// Dequeues wait in the background, so other messages are still served.
+func (k *KVStore) handleMessage(ctx context.Context, c *wsClient, data []byte) {
+	if c.format == formatJSONRPC {
+		k.handleRPC(ctx, c, data)
+		return
+	}
+	var m wsMessage
+	if err := c.unmarshal(data, &m); err != nil {
+		msg := "invalid json"
+		if c.format == formatCBOR {
+			msg = "invalid cbor"
+		}
+		k.reply(c, wsReply{Type: "error", Error: msg})
+		return
+	}
+	if m.Op == "" {
+		m.Op = m.Type
+	}
+	k.runMessage(ctx, c, m, func(res wsReply) { k.reply(c, res) })
+}
+
+// runMessage runs m for client c and passes the reply to done, which is
+// called from another goroutine for dequeues.
+func (k *KVStore) runMessage(ctx context.Context, c *wsClient, m wsMessage, done func(wsReply)) {
+	res := wsReply{Type: "ack", ID: m.ID, Op: m.Op, Key: m.Key, Topic: m.Topic}
+	var err error
+	switch m.Op {
+	case "set":
+		res.Rev, err = k.setMessage(ctx, c, m)
+	case "delete":
+		var ik string
+		if ik, err = c.mayWrite(m.Key); err == nil && !k.Delete(ctx, ik) {
+			err = errors.New("not found")
+		}
+	case "get":
+		if !validName(m.Key) {
+			err = errors.New("invalid key")
+			break
+		}
+		e, ok := k.GetEntry(nsKey(c.ns, m.Key))
+		if !ok || !c.id.mayAccess(c.ns, m.Key) {
+			err = errors.New("not found")
+			break
+		}
+		res.Value, res.Encoding = e.jsonValue()
+		res.Rev = e.Rev
+	case "ephemeral":
+		res.Rev, err = k.setEphemeral(ctx, c, m)
+	case "incr", "decr":
+		var n int64
+		if n, err = k.incrMessage(ctx, c, m); err == nil {
+			res.Value = strconv.FormatInt(n, 10)
+		}
+	case "enqueue", "ack":
+		if err = c.mayUseQueue(m.Key); err != nil {
+			break
+		}
+		if m.Op == "enqueue" {
+			err = k.Enqueue(ctx, m.Key, m.Value)
+		} else {
+			err = k.Ack(ctx, m.Key, m.Delivery)
+		}
+	case "subscribe", "unsubscribe":
+		if m.Topic != "" {
+			err = k.subscribeTopic(c, m.Topic, m.Op == "subscribe")
+		} else {
+			err = k.subscribeKeys(c, m.Key, m.Prefix, m.Op == "subscribe")
+		}
+	case "dequeue":
+		if err = c.mayUseQueue(m.Key); err != nil {
+			break
+		}
+		var wait, visibility time.Duration
+		if wait, visibility, err = queueTimes(m.Wait, m.Visibility); err != nil {
+			break
+		}
+		go func() {
+			d, ok, err := k.Dequeue(ctx, m.Key, wait, visibility)
+			if err != nil {
+				res.Type, res.Error = "error", err.Error()
+			} else if ok {
+				res.Delivery, res.Value = d.ID, d.Value
+			}
+			done(res)
+		}()
+		return
+	default:
+		err = errors.New("unknown op")
+	}
+	if err != nil {
+		res.Type, res.Error = "error", err.Error()
+	}
+	done(res)
+}
+
+// mayWrite returns the internal key for key in the namespace of client
+// c, or why c may not write it.
+func (c *wsClient) mayWrite(key string) (string, error) {
+	if !validName(key) {
+		return "", errors.New("invalid key")
+	}
+	ik := nsKey(c.ns, key)
+	return ik, c.mayWriteKey(ik)
+}
+
+// mayUseQueue reports why client c may not use the queue name, or nil if
+// it may. Queues are shared by all namespaces.
+func (c *wsClient) mayUseQueue(name string) error {
+	if !validName(name) {
+		return errors.New("invalid queue name")
+	}
+	return c.mayWriteKey(queuePrefix + name)
+}
+
+// mayWriteKey reports why client c may not write the internal key ik, or
+// nil if it may.
+func (c *wsClient) mayWriteKey(ik string) error {
+	return writeAccess(c.id, ik)
+}
+
+// writeAccess applies the checks the HTTP middleware makes for write
+// routes, --read-only and the write role, and keyAccess to a write of ik
+// that arrives over a connection instead.
+func writeAccess(id *Identity, ik string) error {
+	if readOnly {
+		return errors.New("server is read-only")
+	}
+	if authEnabled() && (id == nil || id.Role < RoleWrite) {
+		return errors.New("forbidden: needs the write role")
+	}
+	return keyAccess(id, ik)
+}
+
+// setMessage runs a "set" message and returns the revision of the write.
+func (k *KVStore) setMessage(ctx context.Context, c *wsClient, m wsMessage) (uint64, error) {
+	ik, err := c.mayWrite(m.Key)
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := parseTTL(m.TTL)
+	if err != nil {
+		return 0, errors.New("invalid ttl")
+	}
+	if !validType(m.ValueType) {
+		return 0, errors.New("invalid type")
+	}
+	value, err := normalizeTyped(m.ValueType, m.Value)
+	if err != nil {
+		return 0, err
+	}
+	e := newEntry(value, ttl)
+	e.ContentType, e.Type = m.ContentType, m.ValueType
+	_, rev, err := k.PutIf(ctx, ik, e, nil)
+	return rev, err
+}
+
+// incrMessage runs an "incr" or "decr" message and returns the new value
+// of the counter.
+func (k *KVStore) incrMessage(ctx context.Context, c *wsClient, m wsMessage) (int64, error) {
+	ik, err := c.mayWrite(m.Key)
+	if err != nil {
+		return 0, err
+	}
+	by := int64(1)
+	if m.By != nil {
+		by = *m.By
+	}
+	if m.Op == "decr" {
+		by = -by
+	}
+	return k.Incr(ctx, ik, by)
+}
+
+// reply queues v for client c behind the events already queued.
+func (k *KVStore) reply(c *wsClient, v any) {
+	data := c.marshal(v)
+	k.connMu.Lock()
+	defer k.connMu.Unlock()
+	k.queue(c, data)
+}